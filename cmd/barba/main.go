@@ -0,0 +1,116 @@
+// barba 仮想マシンを操作するコマンド
+//
+//	barba debug [-stack n] [-mem n] [-globals n] [-input file] <file>
+//
+// fileはアセンブリかバイトコード. debugのコマンドは標準入力から1行ずつ読む.
+package main
+
+import (
+	"barba/runtime"
+	"barba/runtime/asm"
+	"barba/runtime/debugger"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "barba:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: barba debug [-stack n] [-mem n] [-globals n] [-input file] <file>")
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return fmt.Errorf("missing command")
+	}
+	switch args[0] {
+	case "debug":
+		return debug(args[1:], stdin, stdout, stderr)
+	default:
+		usage(stderr)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func debug(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	stackSize := fs.Int("stack", 1000, "stack size")
+	memSize := fs.Int("mem", 1000, "memory size")
+	globals := fs.Int("globals", 0, "memory cells kept for globals before the heap")
+	input := fs.String("input", "", "file used as stdin of the program")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return fmt.Errorf("expect one program file")
+	}
+
+	prog, labels, info, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// コマンドを標準入力から読むので, プログラムの入力は別に渡す
+	var in io.Reader = bytes.NewReader(nil)
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	rt := runtime.NewRuntime(*stackSize, *memSize, runtime.WithStdin(in), runtime.WithStdout(stdout), runtime.WithStderr(stderr), runtime.WithHeapBase(*globals), runtime.WithDebugInfo(info), runtime.WithVerify())
+	if err := rt.Load(prog); err != nil {
+		return err
+	}
+	if err := rt.CollectLabels(); err != nil {
+		return err
+	}
+
+	d := debugger.New(rt, labels, stdout)
+	if isTerminal(stdin) {
+		d.Prompt = "(barba) "
+	}
+	return d.Run(stdin)
+}
+
+// load バイトコードならそのまま, それ以外はアセンブリとして読む
+// デバッグ情報はアセンブリのときだけ作る
+func load(path string) (runtime.Program, map[string]runtime.Label, *runtime.DebugInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if bytes.HasPrefix(data, []byte("BRBA")) {
+		var prog runtime.Program
+		if err := prog.UnmarshalBinary(data); err != nil {
+			return nil, nil, nil, err
+		}
+		return prog, nil, nil, nil
+	}
+	unit, err := asm.Parse(string(data))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return unit.Program, unit.Labels, unit.DebugInfo(path), nil
+}
+
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
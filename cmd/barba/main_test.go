@@ -0,0 +1,56 @@
+package main
+
+import (
+	"barba/runtime/asm"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const src = `main:
+	syscall readline stdin r1
+	syscall write stdout r1
+	ret
+`
+
+func TestRun_Debug(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.s")
+	assert.Nil(t, os.WriteFile(path, []byte(src), 0o644))
+	input := filepath.Join(dir, "input.txt")
+	assert.Nil(t, os.WriteFile(input, []byte("hello\n"), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"debug", "-input", input, path}, strings.NewReader("break main\ncontinue\ncontinue\n"), &stdout, &stderr)
+	assert.Nil(t, err)
+	assert.Equal(t, "breakpoint at pc=5 in main: syscall readline stdin r1\npc=5 in main: syscall readline stdin r1\nhelloexited with status 0\n", stdout.String())
+
+	// バイトコードも読める
+	prog, err := asm.Assemble(src)
+	assert.Nil(t, err)
+	data, err := prog.MarshalBinary()
+	assert.Nil(t, err)
+	path = filepath.Join(dir, "main.brb")
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+	stdout.Reset()
+	err = run([]string{"debug", path}, strings.NewReader("break l_0\nstep\nprint r1\n"), &stdout, &stderr)
+	assert.Nil(t, err)
+	assert.Equal(t, "breakpoint at pc=5 in main: syscall readline stdin r1\npc=5 in main: syscall readline stdin r1\nr1 = -\n", stdout.String())
+}
+
+func TestRun_Error(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.NotNil(t, run(nil, nil, &stdout, &stderr))
+	assert.NotNil(t, run([]string{"nope"}, nil, &stdout, &stderr))
+	assert.NotNil(t, run([]string{"debug"}, nil, &stdout, &stderr))
+	assert.NotNil(t, run([]string{"debug", "/no/such/file"}, nil, &stdout, &stderr))
+
+	// 実行する前にVerifyで止める
+	path := filepath.Join(t.TempDir(), "bad.s")
+	assert.Nil(t, os.WriteFile(path, []byte("main:\n\tmov 1 r1\n\tret\n"), 0o644))
+	err := run([]string{"debug", path}, strings.NewReader(""), &stdout, &stderr)
+	assert.EqualError(t, err, "pc=2: Mov operand 1: want register or stack offset, but got: runtime.Integer(1)")
+}
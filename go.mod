@@ -2,7 +2,10 @@ module barba
 
 go 1.23
 
-require github.com/stretchr/testify v1.10.0
+require (
+	github.com/google/pprof v0.0.0-20250403155104-27863c87afa6
+	github.com/stretchr/testify v1.10.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
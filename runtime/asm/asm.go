@@ -0,0 +1,405 @@
+// Package asm runtime/ABI.mdで使っているテキスト形式のアセンブリを runtime.Program に変換する.
+//
+//	fib:
+//	  push bp
+//	  mov bp sp
+//	  mov [bp-1] [bp+2]
+//	  syscall write stdout r1
+//	  call fib
+//
+// ラベルは名前で書ける. mainはLabel(0), l_3のような名前はLabel(3)になり,
+// それ以外の名前には空いている番号が出現順に割り当てられる.
+package asm
+
+import (
+	"barba/runtime"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error 位置情報付きのパースエラー
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Unit アセンブルした結果
+type Unit struct {
+	Program   runtime.Program
+	Labels    map[string]runtime.Label  // ラベル名: ラベル番号
+	Positions map[int]runtime.SourcePos // 命令の位置: ソースの位置
+}
+
+// DebugInfo スタックトレースに使うデバッグ情報, fileはソースのファイル名
+func (u *Unit) DebugInfo(file string) *runtime.DebugInfo {
+	info := &runtime.DebugInfo{
+		Names:     LabelNames(u.Labels),
+		Positions: map[int]runtime.SourcePos{},
+	}
+	for pc, pos := range u.Positions {
+		pos.File = file
+		info.Positions[pc] = pos
+	}
+	return info
+}
+
+// 名前解決前のラベル
+type labelDef struct{ tok token }
+type labelRef struct{ tok token }
+
+// 位置付きの命令
+type instruction struct {
+	op  runtime.Opcode
+	tok token
+}
+
+// Assemble ソースをプログラムに変換する
+func Assemble(src string) (runtime.Program, error) {
+	unit, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return unit.Program, nil
+}
+
+// Parse ソースをプログラムに変換し, ラベル名の対応も返す
+func Parse(src string) (*Unit, error) {
+	var items []any
+	for i, line := range strings.Split(src, "\n") {
+		lineItems, err := parseLine([]rune(line), i+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, lineItems...)
+	}
+
+	labels, err := resolveLabels(items)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := runtime.Program{}
+	positions := map[int]runtime.SourcePos{}
+	for _, item := range items {
+		switch item := item.(type) {
+		case instruction:
+			positions[len(prog)] = runtime.SourcePos{Line: item.tok.line, Column: item.tok.col}
+			prog = append(prog, item.op)
+		case labelDef:
+			prog = append(prog, runtime.DefLabel(labels[item.tok.text]))
+		case labelRef:
+			prog = append(prog, labels[item.tok.text])
+		case runtime.Object:
+			prog = append(prog, item)
+		}
+	}
+	return &Unit{Program: prog, Labels: labels, Positions: positions}, nil
+}
+
+// ParseOperand オペランド1つを読む, ラベル名はlabelsかmainやl_3のような名前で解決する
+func ParseOperand(src string, labels map[string]runtime.Label) (runtime.Object, error) {
+	toks, err := lexLine([]rune(src), 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, &Error{1, 1, "expect operand"}
+	}
+	pos := 0
+	operand, err := parseOperand(toks, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if pos < len(toks) {
+		return nil, &Error{toks[pos].line, toks[pos].col, fmt.Sprintf("unexpected %q", toks[pos].text)}
+	}
+	ref, ok := operand.(labelRef)
+	if !ok {
+		return operand.(runtime.Object), nil
+	}
+	if label, ok := labels[ref.tok.text]; ok {
+		return label, nil
+	}
+	if label, ok := explicitLabel(ref.tok.text); ok {
+		return label, nil
+	}
+	return nil, &Error{ref.tok.line, ref.tok.col, fmt.Sprintf("undefined label: %s", ref.tok.text)}
+}
+
+func parseLine(src []rune, line int) ([]any, error) {
+	toks, err := lexLine(src, line)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	pos := 0
+	// ラベル定義
+	if len(toks) >= 2 && toks[0].kind == tkIdent && toks[1].kind == tkColon {
+		if isReserved(toks[0].text) {
+			return nil, &Error{toks[0].line, toks[0].col, fmt.Sprintf("reserved name used as label: %s", toks[0].text)}
+		}
+		items = append(items, labelDef{toks[0]})
+		pos = 2
+	}
+	if pos == len(toks) {
+		return items, nil
+	}
+	// 命令
+	mnemonic := toks[pos]
+	if mnemonic.kind != tkIdent {
+		return nil, &Error{mnemonic.line, mnemonic.col, fmt.Sprintf("expect instruction, but got %q", mnemonic.text)}
+	}
+	op, ok := runtime.LookupOpcode(mnemonic.text)
+	if !ok {
+		return nil, &Error{mnemonic.line, mnemonic.col, fmt.Sprintf("unknown instruction: %s", mnemonic.text)}
+	}
+	items = append(items, instruction{op, mnemonic})
+	pos++
+	// オペランド
+	count := 0
+	for pos < len(toks) {
+		operand, err := parseOperand(toks, &pos)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, operand)
+		count++
+	}
+	if want := runtime.Operand(op); count != want {
+		return nil, &Error{mnemonic.line, mnemonic.col, fmt.Sprintf("%s expects %d operands, but got %d", mnemonic.text, want, count)}
+	}
+	return items, nil
+}
+
+func parseOperand(toks []token, pos *int) (any, error) {
+	tok := toks[*pos]
+	*pos++
+	switch tok.kind {
+	case tkIdent:
+		if tok.text == "mem" {
+			return parseMemoryOffset(tok, toks, pos)
+		}
+		if obj, ok := lookupName(tok.text); ok {
+			return obj, nil
+		}
+		return labelRef{tok}, nil
+	case tkInt:
+		i, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, &Error{tok.line, tok.col, fmt.Sprintf("invalid integer: %s", tok.text)}
+		}
+		return runtime.Integer(i), nil
+	case tkFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &Error{tok.line, tok.col, fmt.Sprintf("invalid float: %s", tok.text)}
+		}
+		return runtime.Float(f), nil
+	case tkChar:
+		r, err := unquoteChar(tok)
+		if err != nil {
+			return nil, err
+		}
+		return runtime.Character(r), nil
+	case tkString:
+		str, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, &Error{tok.line, tok.col, fmt.Sprintf("invalid string literal: %s", tok.text)}
+		}
+		return runtime.String(str), nil
+	case tkLBracket:
+		return parseStackOffset(tok, toks, pos)
+	default:
+		return nil, &Error{tok.line, tok.col, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+}
+
+// parseStackOffset [bp-1], [sp+2], [bp]
+func parseStackOffset(open token, toks []token, pos *int) (any, error) {
+	base, distance, err := parseBracket(open, toks, pos)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return nil, &Error{open.line, open.col, "offset base must be bp or sp"}
+	}
+	reg, ok := runtime.LookupRegister(base.text)
+	if !ok || (reg != runtime.BasePointer && reg != runtime.StackPointer) {
+		return nil, &Error{base.line, base.col, fmt.Sprintf("offset base must be bp or sp, but got %q", base.text)}
+	}
+	return *runtime.NewStackRelativeOffset(reg, distance), nil
+}
+
+// parseMemoryOffset mem[3], mem[r1+2], mem[r1]
+func parseMemoryOffset(mem token, toks []token, pos *int) (any, error) {
+	if *pos >= len(toks) || toks[*pos].kind != tkLBracket {
+		return nil, &Error{mem.line, mem.col, "expect '[' after mem"}
+	}
+	open := toks[*pos]
+	*pos++
+	base, distance, err := parseBracket(open, toks, pos)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return runtime.MemoryOffset(distance), nil
+	}
+	reg, ok := runtime.LookupRegister(base.text)
+	if !ok {
+		return nil, &Error{base.line, base.col, fmt.Sprintf("memory offset base must be register, but got %q", base.text)}
+	}
+	return *runtime.NewMemoryRelativeOffset(reg, distance), nil
+}
+
+// parseBracket [ident], [ident+n], [ident-n], [n]を読む, 基準がなければbaseはnil
+func parseBracket(open token, toks []token, pos *int) (*token, int, error) {
+	next := func() (token, error) {
+		if *pos >= len(toks) {
+			return token{}, &Error{open.line, open.col, "unterminated offset"}
+		}
+		tok := toks[*pos]
+		*pos++
+		return tok, nil
+	}
+
+	tok, err := next()
+	if err != nil {
+		return nil, 0, err
+	}
+	var base *token
+	switch tok.kind {
+	case tkIdent:
+		ident := tok
+		base = &ident
+		if tok, err = next(); err != nil {
+			return nil, 0, err
+		}
+		if tok.kind == tkPlus {
+			if tok, err = next(); err != nil {
+				return nil, 0, err
+			}
+			if tok.kind != tkInt {
+				return nil, 0, &Error{tok.line, tok.col, fmt.Sprintf("expect number after '+', but got %q", tok.text)}
+			}
+		}
+	case tkInt:
+	default:
+		return nil, 0, &Error{tok.line, tok.col, fmt.Sprintf("unexpected %q in offset", tok.text)}
+	}
+
+	distance := 0
+	if tok.kind == tkInt {
+		if distance, err = strconv.Atoi(tok.text); err != nil {
+			return nil, 0, &Error{tok.line, tok.col, fmt.Sprintf("invalid integer: %s", tok.text)}
+		}
+		if tok, err = next(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if tok.kind != tkRBracket {
+		return nil, 0, &Error{tok.line, tok.col, fmt.Sprintf("expect ']', but got %q", tok.text)}
+	}
+	return base, distance, nil
+}
+
+// lookupName ラベル以外の名前付きオペランド
+func lookupName(name string) (runtime.Object, bool) {
+	if reg, ok := runtime.LookupRegister(name); ok {
+		return reg, true
+	}
+	if no, ok := runtime.LookupSystemCall(name); ok {
+		return no, true
+	}
+	if std, ok := runtime.LookupStandardIO(name); ok {
+		return std, true
+	}
+	switch name {
+	case "true":
+		return runtime.True, true
+	case "false":
+		return runtime.False, true
+	case "null":
+		return runtime.Null{}, true
+	default:
+		return nil, false
+	}
+}
+
+func isReserved(name string) bool {
+	if name == "mem" {
+		return true
+	}
+	if _, ok := lookupName(name); ok {
+		return true
+	}
+	_, ok := runtime.LookupOpcode(name)
+	return ok
+}
+
+// explicitLabel mainやl_3のように番号が決まっているラベル
+func explicitLabel(name string) (runtime.Label, bool) {
+	if name == "main" {
+		return runtime.Label(0), true
+	}
+	if no, ok := strings.CutPrefix(name, "l_"); ok {
+		if i, err := strconv.Atoi(no); err == nil {
+			return runtime.Label(i), true
+		}
+	}
+	return 0, false
+}
+
+// resolveLabels ラベル名に番号を割り当てる
+func resolveLabels(items []any) (map[string]runtime.Label, error) {
+	labels := map[string]runtime.Label{}
+	used := map[runtime.Label]bool{}
+	defined := map[string]bool{}
+	var names []string
+	for _, item := range items {
+		var tok token
+		switch item := item.(type) {
+		case labelDef:
+			if defined[item.tok.text] {
+				return nil, &Error{item.tok.line, item.tok.col, fmt.Sprintf("label already defined: %s", item.tok.text)}
+			}
+			defined[item.tok.text] = true
+			tok = item.tok
+		case labelRef:
+			tok = item.tok
+		default:
+			continue
+		}
+		if _, ok := labels[tok.text]; ok {
+			continue
+		}
+		if label, ok := explicitLabel(tok.text); ok {
+			labels[tok.text] = label
+			used[label] = true
+			continue
+		}
+		labels[tok.text] = -1 // 仮
+		names = append(names, tok.text)
+	}
+	// 定義されていないラベルの参照
+	for _, item := range items {
+		if ref, ok := item.(labelRef); ok && !defined[ref.tok.text] {
+			return nil, &Error{ref.tok.line, ref.tok.col, fmt.Sprintf("undefined label: %s", ref.tok.text)}
+		}
+	}
+	// 名前付きラベルに空いている番号を出現順に割り当てる
+	next := runtime.Label(1)
+	for _, name := range names {
+		for used[next] {
+			next++
+		}
+		labels[name] = next
+		used[next] = true
+	}
+	return labels, nil
+}
@@ -0,0 +1,266 @@
+package asm
+
+import (
+	"barba/runtime"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect runtime.Program
+	}{
+		{
+			"label and ret",
+			"main:\n  ret\n",
+			runtime.Program{runtime.DefLabel(0), runtime.Ret},
+		},
+		{
+			"stack offsets",
+			"mov [bp-1] [bp+2]\npush [sp]",
+			runtime.Program{
+				runtime.Mov, *runtime.NewBPOffset(-1), *runtime.NewBPOffset(2),
+				runtime.Push, *runtime.NewStackRelativeOffset(runtime.StackPointer, 0),
+			},
+		},
+		{
+			"memory offsets",
+			"load r1 mem[3]\nstore mem[r2-1] r1\nstore mem[r2] 'a'",
+			runtime.Program{
+				runtime.Load, runtime.R1, runtime.MemoryOffset(3),
+				runtime.Store, *runtime.NewMemoryRelativeOffset(runtime.R2, -1), runtime.R1,
+				runtime.Store, *runtime.NewMemoryRelativeOffset(runtime.R2, 0), runtime.Character('a'),
+			},
+		},
+		{
+			"immediates",
+			"push 10\npush -3\npush 'h'\npush '\\n'\npush true\npush null",
+			runtime.Program{
+				runtime.Push, runtime.Integer(10),
+				runtime.Push, runtime.Integer(-3),
+				runtime.Push, runtime.Character('h'),
+				runtime.Push, runtime.Character('\n'),
+				runtime.Push, runtime.True,
+				runtime.Push, runtime.Null{},
+			},
+		},
+		{
+			"floats",
+			"mov r1 1.5\nmov r2 -2e3\nmov r3 1.25E-2\nitof r4\nftoi r1",
+			runtime.Program{
+				runtime.Mov, runtime.R1, runtime.Float(1.5),
+				runtime.Mov, runtime.R2, runtime.Float(-2000),
+				runtime.Mov, runtime.R3, runtime.Float(0.0125),
+				runtime.Itof, runtime.R4,
+				runtime.Ftoi, runtime.R1,
+			},
+		},
+		{
+			"strings",
+			"mov r1 \"hello, \\\"world\\\"\\n\"\nconcat r1 \"\" // comment\nindex r2 r1 0\nsubstr r1 0 5",
+			runtime.Program{
+				runtime.Mov, runtime.R1, runtime.String("hello, \"world\"\n"),
+				runtime.Concat, runtime.R1, runtime.String(""),
+				runtime.Index, runtime.R2, runtime.R1, runtime.Integer(0),
+				runtime.Substr, runtime.R1, runtime.Integer(0), runtime.Integer(5),
+			},
+		},
+		{
+			"syscall",
+			"syscall write stdout r1 // print r1\nsyscall write stderr ' '\nsyscall syscall_256 r1 null\nsyscall \"config.get\" r1 null",
+			runtime.Program{
+				runtime.Syscall, runtime.Write, runtime.StdOut, runtime.R1,
+				runtime.Syscall, runtime.Write, runtime.StdErr, runtime.Character(' '),
+				runtime.Syscall, runtime.HostSystemCallBase, runtime.R1, runtime.Null{},
+				runtime.Syscall, runtime.String("config.get"), runtime.R1, runtime.Null{},
+			},
+		},
+		{
+			"named labels",
+			"main:\n  call fib\n  ret\nfib:\n  jmp end\nend:\n  ret",
+			runtime.Program{
+				runtime.DefLabel(0),
+				runtime.Call, runtime.Label(1),
+				runtime.Ret,
+				runtime.DefLabel(1),
+				runtime.Jmp, runtime.Label(2),
+				runtime.DefLabel(2),
+				runtime.Ret,
+			},
+		},
+		{
+			"numbered labels are kept",
+			"l_1:\n  jmp next\nnext:\n  call l_1",
+			runtime.Program{
+				runtime.DefLabel(1),
+				runtime.Jmp, runtime.Label(2), // 1は使われているので2
+				runtime.DefLabel(2),
+				runtime.Call, runtime.Label(1),
+			},
+		},
+		{
+			"case insensitive opcode",
+			"MOV r1 bp",
+			runtime.Program{runtime.Mov, runtime.R1, runtime.BasePointer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Assemble(tt.src)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expect, prog)
+		})
+	}
+}
+
+func TestAssemble_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		line   int
+		column int
+	}{
+		{"unknown instruction", "main:\n  jz foo", 2, 3},
+		{"operand count", "main:\n  mov r1", 2, 3},
+		{"bad offset base", "push [r1+1]", 1, 7},
+		{"unterminated offset", "push [bp-1", 1, 6},
+		{"stack offset without base", "push [1]", 1, 6},
+		{"memory without bracket", "load r1 mem 3", 1, 9},
+		{"bad memory base", "load r1 mem[foo]", 1, 13},
+		{"undefined label", "main:\n  call fib", 2, 8},
+		{"duplicated label", "main:\nmain:", 2, 1},
+		{"reserved label", "r1:", 1, 1},
+		{"unexpected char", "push $1", 1, 6},
+		{"unterminated string", "push \"abc", 1, 6},
+		{"invalid string escape", "push \"\\q\"", 1, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			var asmErr *Error
+			assert.ErrorAs(t, err, &asmErr)
+			assert.Equal(t, tt.line, asmErr.Line)
+			assert.Equal(t, tt.column, asmErr.Column)
+		})
+	}
+}
+
+func TestParseOperand(t *testing.T) {
+	labels := map[string]runtime.Label{"fib": runtime.Label(1)}
+	tests := []struct {
+		src    string
+		expect runtime.Object
+	}{
+		{"[bp-1]", *runtime.NewBPOffset(-1)},
+		{"mem[r1+2]", *runtime.NewMemoryRelativeOffset(runtime.R1, 2)},
+		{"r10", runtime.R10},
+		{"-3", runtime.Integer(-3)},
+		{"fib", runtime.Label(1)},
+		{"main", runtime.Label(0)},
+		{"l_-1", runtime.Label(-1)},
+	}
+	for _, tt := range tests {
+		obj, err := ParseOperand(tt.src, labels)
+		assert.Nil(t, err, tt.src)
+		assert.Equal(t, tt.expect, obj, tt.src)
+	}
+	for _, src := range []string{"", "r1 r2", "foo", "[r1]"} {
+		_, err := ParseOperand(src, labels)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestAssemble_Fibonacci(t *testing.T) {
+	prog, err := Assemble(`
+fib:
+	push bp
+	mov bp sp
+	sub sp 1
+	mov [bp-1] [bp+2]
+	// if n < 2
+	push [bp-1]
+	push 2
+	pop r2
+	pop r1
+	lt r1 r2
+	je if_block
+	jmp if_end
+if_block:
+	push [bp-1]
+	pop r1
+	mov r10 r1
+	mov sp bp
+	pop bp
+	ret
+if_end:
+	// fib(n-1)
+	push [bp-1]
+	push 1
+	pop r2
+	pop r1
+	sub r1 r2
+	push r1
+	call fib
+	push 1
+	pop r1
+	add sp r1
+	push r10
+	// fib(n-2)
+	push [bp-1]
+	push 2
+	pop r2
+	pop r1
+	sub r1 r2
+	push r1
+	call fib
+	push 1
+	pop r1
+	add sp r1
+	push r10
+	// fib(n-1) + fib(n-2)
+	pop r2
+	pop r1
+	add r1 r2
+	push r1
+	pop r1
+	mov r10 r1
+	mov sp bp
+	pop bp
+	ret
+
+main:
+	push bp
+	mov bp sp
+	push 10
+	call fib
+	push 1
+	pop r1
+	add sp r1
+	mov acm1 r10
+	mov sp bp
+	pop bp
+	ret
+`)
+	assert.Nil(t, err)
+	rt := runtime.NewRuntime(100, 10)
+	rt.Load(prog)
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 55, rt.Status())
+}
+
+func TestUnit_DebugInfo(t *testing.T) {
+	unit, err := Parse("main:\n  call fib\n  ret\nfib:\n  mov r1 1 // comment\n\n\tret")
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]runtime.SourcePos{
+		1: {Line: 2, Column: 3},
+		3: {Line: 3, Column: 3},
+		5: {Line: 5, Column: 3},
+		8: {Line: 7, Column: 2},
+	}, unit.Positions)
+	info := unit.DebugInfo("fib.s")
+	assert.Equal(t, "fib", info.Names[runtime.Label(1)])
+	assert.Equal(t, runtime.SourcePos{File: "fib.s", Line: 5, Column: 3}, info.Positions[5])
+}
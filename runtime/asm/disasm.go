@@ -0,0 +1,102 @@
+package asm
+
+import (
+	"barba/runtime"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Disassemble プログラムをABI.mdと同じ形式のテキストに変換する
+func Disassemble(prog runtime.Program) (string, error) {
+	return DisassembleWithLabels(prog, nil)
+}
+
+// DisassembleWithLabels Parseで得たラベル名を使ってプログラムをテキストに変換する
+func DisassembleWithLabels(prog runtime.Program, labels map[string]runtime.Label) (string, error) {
+	names := LabelNames(labels)
+	var sb strings.Builder
+	for pc := 0; pc < len(prog); {
+		switch code := prog[pc]; code.(type) {
+		case runtime.DefLabel:
+			sb.WriteString(names.Name(runtime.Label(code.Value())))
+			sb.WriteString(":\n")
+			pc++
+		case runtime.Opcode:
+			line, err := FormatInstruction(prog, pc, names)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("\t")
+			sb.WriteString(line)
+			sb.WriteString("\n")
+			pc += 1 + runtime.Operand(code.(runtime.Opcode))
+		default:
+			return "", fmt.Errorf("pc(%d): unexpected code: %v", pc, code)
+		}
+	}
+	return sb.String(), nil
+}
+
+// Names ラベル番号: ラベル名
+type Names map[runtime.Label]string
+
+// LabelNames Parseで得たラベル名の対応を逆引きできるようにする
+func LabelNames(labels map[string]runtime.Label) Names {
+	names := Names{}
+	for name, label := range labels {
+		names[label] = name
+	}
+	return names
+}
+
+// Name 名前がなければmainかl_3のような名前にする
+func (n Names) Name(label runtime.Label) string {
+	return runtime.LabelName(label, n)
+}
+
+// FormatInstruction pcにある命令1つをテキストにする
+func FormatInstruction(prog runtime.Program, pc int, names Names) (string, error) {
+	op, ok := prog[pc].(runtime.Opcode)
+	if !ok {
+		return "", fmt.Errorf("pc(%d): not an opcode: %v", pc, prog[pc])
+	}
+	if pc+runtime.Operand(op) >= len(prog) {
+		return "", fmt.Errorf("pc(%d): %v expects %d operands, but program ends", pc, op, runtime.Operand(op))
+	}
+	parts := []string{strings.ToLower(op.String())}
+	for i := 1; i <= runtime.Operand(op); i++ {
+		operand, err := FormatOperand(prog[pc+i], names)
+		if err != nil {
+			return "", fmt.Errorf("pc(%d): %w", pc+i, err)
+		}
+		parts = append(parts, operand)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// FormatOperand オペランド1つをアセンブラが読める形式にする
+func FormatOperand(obj runtime.Object, names Names) (string, error) {
+	switch obj := obj.(type) {
+	case runtime.Label:
+		return names.Name(obj), nil
+	case runtime.Character:
+		return strconv.QuoteRune(rune(obj)), nil
+	case runtime.String:
+		return strconv.Quote(string(obj)), nil
+	case runtime.Float:
+		// NaNと無限大はリテラルで書けない
+		if math.IsNaN(float64(obj)) || math.IsInf(float64(obj), 0) {
+			return "", fmt.Errorf("unsupported operand: %v", obj)
+		}
+		return obj.String(), nil
+	case runtime.Integer, runtime.Bool, runtime.Null, runtime.Register,
+		runtime.SystemCall, runtime.StandardIO,
+		runtime.StackRelativeOffset, *runtime.StackRelativeOffset,
+		runtime.MemoryOffset, runtime.MemoryRelativeOffset:
+		return obj.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported operand: %v", obj)
+	}
+}
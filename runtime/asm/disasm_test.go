@@ -0,0 +1,117 @@
+package asm
+
+import (
+	"barba/runtime"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	prog := runtime.Program{
+		runtime.DefLabel(1),
+		runtime.Push, runtime.BasePointer,
+		runtime.Mov, runtime.BasePointer, runtime.StackPointer,
+		runtime.Mov, *runtime.NewBPOffset(-1), *runtime.NewBPOffset(2),
+		runtime.Syscall, runtime.Write, runtime.StdOut, runtime.Character('\n'),
+		runtime.Eq, runtime.R1, runtime.Null{},
+		runtime.Je, runtime.Label(0),
+		runtime.Ret,
+		runtime.DefLabel(0),
+		runtime.Call, runtime.Label(1),
+		runtime.Exit,
+	}
+	src, err := Disassemble(prog)
+	assert.Nil(t, err)
+	assert.Equal(t, `l_1:
+	push bp
+	mov bp sp
+	mov [bp-1] [bp+2]
+	syscall write stdout '\n'
+	eq r1 null
+	je main
+	ret
+main:
+	call l_1
+	exit
+`, src)
+}
+
+func TestDisassemble_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		prog runtime.Program
+	}{
+		{"missing operand", runtime.Program{runtime.Mov, runtime.R1}},
+		{"operand without opcode", runtime.Program{runtime.Integer(1)}},
+		{"unsupported operand", runtime.Program{runtime.Push, runtime.ProgramAbsoluteOffset(3)}},
+		{"constant reference", runtime.Program{runtime.Push, runtime.Constant(0)}},
+		{"nan", runtime.Program{runtime.Push, runtime.Float(math.NaN())}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Disassemble(tt.prog)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestDisassemble_RoundTrip(t *testing.T) {
+	unit, err := Parse(`
+fib:
+	push bp
+	mov bp sp
+	sub sp 1
+	mov [bp-1] [bp+2]
+	push [bp-1]
+	push 2
+	pop r2
+	pop r1
+	lt r1 r2
+	je if_block
+	jmp if_end
+if_block:
+	mov r10 [bp-1]
+	mov sp bp
+	pop bp
+	ret
+if_end:
+	syscall write stdout ' '
+	mov r4 2.0
+	mul r4 -0.5
+	mov r5 "hello\tworld // ok"
+	len r6 r5
+	syscall syscall_300 r6 null
+	store mem[r1-2] [bp-1]
+	load r3 mem[5]
+	push [sp+0]
+	call fib
+	ret
+main:
+	push -10
+	call fib
+	ret
+`)
+	assert.Nil(t, err)
+	src, err := DisassembleWithLabels(unit.Program, unit.Labels)
+	assert.Nil(t, err)
+	again, err := Parse(src)
+	assert.Nil(t, err)
+	assert.Equal(t, unit.Program, again.Program)
+	assert.Equal(t, unit.Labels, again.Labels)
+
+	// 名前なしでも同じプログラムに戻る
+	src, err = Disassemble(unit.Program)
+	assert.Nil(t, err)
+	prog, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, unit.Program, prog)
+
+	// 擬似プロセスコードのラベルも戻せる
+	prog = runtime.Program{runtime.DefLabel(-1), runtime.Call, runtime.Label(0), runtime.Exit, runtime.DefLabel(0), runtime.Ret}
+	src, err = Disassemble(prog)
+	assert.Nil(t, err)
+	again2, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, prog, again2)
+}
@@ -0,0 +1,156 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tkIdent tokenKind = iota
+	tkInt
+	tkFloat
+	tkChar
+	tkString
+	tkLBracket // [
+	tkRBracket // ]
+	tkPlus     // +
+	tkColon    // :
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	col  int
+}
+
+// lexLine 1行分をトークンに分解する, コメントとカンマは読み飛ばす
+func lexLine(src []rune, line int) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for pos < len(src) {
+		r := src[pos]
+		col := pos + 1
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			pos++
+		case r == '/' && pos+1 < len(src) && src[pos+1] == '/': // コメント
+			return tokens, nil
+		case r == '[':
+			tokens = append(tokens, token{tkLBracket, "[", line, col})
+			pos++
+		case r == ']':
+			tokens = append(tokens, token{tkRBracket, "]", line, col})
+			pos++
+		case r == '+':
+			tokens = append(tokens, token{tkPlus, "+", line, col})
+			pos++
+		case r == ':':
+			tokens = append(tokens, token{tkColon, ":", line, col})
+			pos++
+		case r == '-' || isDigit(r):
+			end := pos + 1
+			for end < len(src) && isDigit(src[end]) {
+				end++
+			}
+			kind := tkInt
+			// 1.5, 2e10, 1.5e-3のような小数
+			if end+1 < len(src) && src[end] == '.' && isDigit(src[end+1]) {
+				kind = tkFloat
+				end++
+				for end < len(src) && isDigit(src[end]) {
+					end++
+				}
+			}
+			if end < len(src) && (src[end] == 'e' || src[end] == 'E') {
+				exp := end + 1
+				if exp < len(src) && (src[exp] == '+' || src[exp] == '-') {
+					exp++
+				}
+				if exp < len(src) && isDigit(src[exp]) {
+					kind = tkFloat
+					end = exp
+					for end < len(src) && isDigit(src[end]) {
+						end++
+					}
+				}
+			}
+			text := string(src[pos:end])
+			if text == "-" {
+				return nil, &Error{line, col, "expect number after '-'"}
+			}
+			tokens = append(tokens, token{kind, text, line, col})
+			pos = end
+		case r == '\'':
+			end := pos + 1
+			for end < len(src) && src[end] != '\'' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, &Error{line, col, "unterminated character literal"}
+			}
+			tokens = append(tokens, token{tkChar, string(src[pos : end+1]), line, col})
+			pos = end + 1
+		case r == '"':
+			end := pos + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, &Error{line, col, "unterminated string literal"}
+			}
+			tokens = append(tokens, token{tkString, string(src[pos : end+1]), line, col})
+			pos = end + 1
+		case isIdentStart(r):
+			end := pos + 1
+			for end < len(src) && (isIdentStart(src[end]) || isDigit(src[end])) {
+				end++
+			}
+			// l_-1のような負の番号のラベル
+			if string(src[pos:end]) == "l_" && end+1 < len(src) && src[end] == '-' && isDigit(src[end+1]) {
+				end++
+				for end < len(src) && isDigit(src[end]) {
+					end++
+				}
+			}
+			tokens = append(tokens, token{tkIdent, string(src[pos:end]), line, col})
+			pos = end
+		default:
+			return nil, &Error{line, col, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return tokens, nil
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// unquoteChar 'a'や'\n'のような文字リテラルを解釈する
+func unquoteChar(tok token) (rune, error) {
+	body := tok.text[1 : len(tok.text)-1]
+	if body == "" {
+		return 0, &Error{tok.line, tok.col, "empty character literal"}
+	}
+	if body == `'` {
+		return 0, &Error{tok.line, tok.col, "character literal must escape '"}
+	}
+	r, _, tail, err := strconv.UnquoteChar(body, '\'')
+	if err != nil || tail != "" {
+		return 0, &Error{tok.line, tok.col, fmt.Sprintf("invalid character literal: %s", tok.text)}
+	}
+	return r, nil
+}
@@ -0,0 +1,424 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// バイトコードファイルの形式
+//
+//	magic    "BRBA"
+//	version  uint16 (big endian)
+//	constant uvarint(個数) + object*
+//	code     uvarint(個数) + (object | const uvarint(定数番号))*
+//
+// objectは1byteの種類に続けて中身を置く. 整数はzigzag varintで, 小数はIEEE 754の8byte(big endian)で書く.
+// 文字列はuvarint(byte数)に続けてUTF-8で書く.
+// 即値(Integer, Character, Bool, Null, List, String)は定数セクションに1度だけ置き, コードからは番号で参照する.
+
+var bytecodeMagic = []byte("BRBA")
+
+// BytecodeVersion objectの種類を足すたびに上げる, ほかの版のファイルは読まない
+//
+//	1 最初の形式
+//	2 MemoryRelativeOffset
+//	3 Float
+//	4 String
+const BytecodeVersion uint16 = 4
+
+var (
+	ErrBadMagic           = errors.New("bytecode: bad magic")
+	ErrUnsupportedVersion = errors.New("bytecode: unsupported version")
+	ErrTruncated          = errors.New("bytecode: truncated")
+	ErrCorrupt            = errors.New("bytecode: corrupt")
+)
+
+type objectTag byte
+
+const (
+	tagInvalid objectTag = iota
+	tagOpcode
+	tagRegister
+	tagInteger
+	tagCharacter
+	tagBool
+	tagNull
+	tagList
+	tagLabel
+	tagDefLabel
+	tagStackRelativeOffset
+	tagSystemCall
+	tagStandardIO
+	tagProgramAbsoluteOffset
+	tagMemoryOffset
+	tagConst // 定数セクションの参照
+	tagMemoryRelativeOffset
+	tagFloat
+	tagString
+	_tag_end
+)
+
+// isConstant 定数セクションに置く即値か
+func isConstant(obj Object) bool {
+	switch obj.(type) {
+	case Integer, Character, Bool, Null, List, String:
+		return true
+	default:
+		return false
+	}
+}
+
+// EncodeProgram プログラムをバイトコードとして書き出す
+func EncodeProgram(w io.Writer, prog Program) error {
+	data, err := prog.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// DecodeProgram バイトコードを読み込む
+func DecodeProgram(r io.Reader) (Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var prog Program
+	if err := prog.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+func (p Program) MarshalBinary() ([]byte, error) {
+	var consts []Object
+	constIndex := map[Object]int{}
+	for _, obj := range p {
+		if !isConstant(obj) {
+			continue
+		}
+		if _, ok := constIndex[obj]; !ok {
+			constIndex[obj] = len(consts)
+			consts = append(consts, obj)
+		}
+	}
+
+	buf := append([]byte{}, bytecodeMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, BytecodeVersion)
+	// 定数セクション
+	buf = binary.AppendUvarint(buf, uint64(len(consts)))
+	for _, obj := range consts {
+		var err error
+		if buf, err = appendObject(buf, obj); err != nil {
+			return nil, err
+		}
+	}
+	// コードセクション
+	buf = binary.AppendUvarint(buf, uint64(len(p)))
+	for pc, obj := range p {
+		if i, ok := constIndex[obj]; ok {
+			buf = append(buf, byte(tagConst))
+			buf = binary.AppendUvarint(buf, uint64(i))
+			continue
+		}
+		var err error
+		if buf, err = appendObject(buf, obj); err != nil {
+			return nil, fmt.Errorf("pc(%d): %w", pc, err)
+		}
+	}
+	return buf, nil
+}
+
+func (p *Program) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	magic, err := d.bytes(len(bytecodeMagic))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, bytecodeMagic) {
+		return ErrBadMagic
+	}
+	version, err := d.bytes(2)
+	if err != nil {
+		return err
+	}
+	if v := binary.BigEndian.Uint16(version); v != BytecodeVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	// 定数セクション
+	n, err := d.count()
+	if err != nil {
+		return err
+	}
+	consts := make([]Object, 0, n)
+	for range n {
+		obj, err := d.object()
+		if err != nil {
+			return err
+		}
+		if !isConstant(obj) {
+			return fmt.Errorf("%w: non-constant in constant section: %v", ErrCorrupt, obj)
+		}
+		consts = append(consts, obj)
+	}
+	// コードセクション
+	n, err = d.count()
+	if err != nil {
+		return err
+	}
+	prog := make(Program, 0, n)
+	for range n {
+		if objectTag(d.peek()) == tagConst {
+			d.pos++
+			i, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			if i >= uint64(len(consts)) {
+				return fmt.Errorf("%w: constant %d out of range", ErrCorrupt, i)
+			}
+			prog = append(prog, consts[i])
+			continue
+		}
+		obj, err := d.object()
+		if err != nil {
+			return err
+		}
+		prog = append(prog, obj)
+	}
+	if d.pos != len(d.buf) {
+		return fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(d.buf)-d.pos)
+	}
+	*p = prog
+	return nil
+}
+
+// appendObject objectを1つ書き出す
+func appendObject(buf []byte, obj Object) ([]byte, error) {
+	switch obj := obj.(type) {
+	case Opcode:
+		buf = append(buf, byte(tagOpcode))
+	case Register:
+		buf = append(buf, byte(tagRegister))
+	case Integer:
+		buf = append(buf, byte(tagInteger))
+	case Character:
+		buf = append(buf, byte(tagCharacter))
+	case Bool:
+		return append(buf, byte(tagBool), byte(obj.Value())), nil
+	case Null:
+		return append(buf, byte(tagNull)), nil
+	case Float:
+		buf = append(buf, byte(tagFloat))
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(float64(obj))), nil
+	case String:
+		buf = append(buf, byte(tagString))
+		buf = binary.AppendUvarint(buf, uint64(len(obj)))
+		return append(buf, obj...), nil
+	case List:
+		buf = append(buf, byte(tagList))
+	case Label:
+		buf = append(buf, byte(tagLabel))
+	case DefLabel:
+		buf = append(buf, byte(tagDefLabel))
+	case StackRelativeOffset:
+		buf = append(buf, byte(tagStackRelativeOffset))
+		buf = binary.AppendVarint(buf, int64(obj.target))
+	case *StackRelativeOffset:
+		if obj == nil {
+			return nil, fmt.Errorf("unsupported object: nil offset")
+		}
+		return appendObject(buf, *obj)
+	case MemoryRelativeOffset:
+		buf = append(buf, byte(tagMemoryRelativeOffset))
+		buf = binary.AppendVarint(buf, int64(obj.target))
+	case SystemCall:
+		buf = append(buf, byte(tagSystemCall))
+	case StandardIO:
+		buf = append(buf, byte(tagStandardIO))
+	case ProgramAbsoluteOffset:
+		buf = append(buf, byte(tagProgramAbsoluteOffset))
+	case MemoryOffset:
+		buf = append(buf, byte(tagMemoryOffset))
+	default:
+		return nil, fmt.Errorf("unsupported object: %v", obj)
+	}
+	return binary.AppendVarint(buf, int64(obj.Value())), nil
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) peek() byte {
+	if d.pos >= len(d.buf) {
+		return byte(tagInvalid)
+	}
+	return d.buf[d.pos]
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if len(d.buf)-d.pos < n {
+		return nil, ErrTruncated
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	switch {
+	case n == 0:
+		return 0, ErrTruncated
+	case n < 0:
+		return 0, fmt.Errorf("%w: varint overflow", ErrCorrupt)
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *decoder) int() (int, error) {
+	v, n := binary.Varint(d.buf[d.pos:])
+	switch {
+	case n == 0:
+		return 0, ErrTruncated
+	case n < 0:
+		return 0, fmt.Errorf("%w: varint overflow", ErrCorrupt)
+	}
+	d.pos += n
+	return int(v), nil
+}
+
+// count 要素数を読む, 1要素は最低1byteなので残りより多ければ壊れている
+func (d *decoder) count() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)-d.pos) {
+		return 0, ErrTruncated
+	}
+	return int(n), nil
+}
+
+// object objectを1つ読む
+func (d *decoder) object() (Object, error) {
+	tag, err := d.bytes(1)
+	if err != nil {
+		return nil, err
+	}
+	if objectTag(tag[0]) == tagInvalid || objectTag(tag[0]) == tagConst || _tag_end <= objectTag(tag[0]) {
+		return nil, fmt.Errorf("%w: unknown object tag: %d", ErrCorrupt, tag[0])
+	}
+	switch objectTag(tag[0]) {
+	case tagBool:
+		b, err := d.bytes(1)
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case 0:
+			return False, nil
+		case 1:
+			return True, nil
+		default:
+			return nil, fmt.Errorf("%w: invalid bool: %d", ErrCorrupt, b[0])
+		}
+	case tagNull:
+		return Null{}, nil
+	case tagFloat:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return Float(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+	case tagString:
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, fmt.Errorf("%w: invalid utf-8 string", ErrCorrupt)
+		}
+		return String(b), nil
+	case tagStackRelativeOffset:
+		target, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		distance, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		if Register(target) != BasePointer && Register(target) != StackPointer {
+			return nil, fmt.Errorf("%w: invalid offset target: %d", ErrCorrupt, target)
+		}
+		return StackRelativeOffset{Register(target), distance}, nil
+	case tagMemoryRelativeOffset:
+		target, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		distance, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		if target < 0 || int(_reg_end) <= target {
+			return nil, fmt.Errorf("%w: invalid offset target: %d", ErrCorrupt, target)
+		}
+		return MemoryRelativeOffset{Register(target), distance}, nil
+	}
+
+	v, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+	switch objectTag(tag[0]) {
+	case tagOpcode:
+		if v < 0 || len(opcodeKinds) <= v || opcodeKinds[v] == "" {
+			return nil, fmt.Errorf("%w: invalid opcode: %d", ErrCorrupt, v)
+		}
+		return Opcode(v), nil
+	case tagRegister:
+		if v < 0 || int(_reg_end) <= v {
+			return nil, fmt.Errorf("%w: invalid register: %d", ErrCorrupt, v)
+		}
+		return Register(v), nil
+	case tagInteger:
+		return Integer(v), nil
+	case tagCharacter:
+		return Character(v), nil
+	case tagList:
+		return List(v), nil
+	case tagLabel:
+		return Label(v), nil
+	case tagDefLabel:
+		return DefLabel(v), nil
+	case tagSystemCall:
+		if SystemCall(v).String() == "" {
+			return nil, fmt.Errorf("%w: invalid syscall: %d", ErrCorrupt, v)
+		}
+		return SystemCall(v), nil
+	case tagStandardIO:
+		if StandardIO(v).String() == "" {
+			return nil, fmt.Errorf("%w: invalid standard io: %d", ErrCorrupt, v)
+		}
+		return StandardIO(v), nil
+	case tagProgramAbsoluteOffset:
+		return ProgramAbsoluteOffset(v), nil
+	default: // tagMemoryOffset
+		return MemoryOffset(v), nil
+	}
+}
@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProgram_MarshalBinary(t *testing.T) {
+	prog := Program{
+		DefLabel(-1),
+		Call, Label(0),
+		Exit,
+		DefLabel(0),
+		Mov, R1, Integer(-300),
+		Mov, R2, Character('あ'),
+		Mov, R3, True,
+		Mov, R4, False,
+		Mov, R5, Null{},
+		Mov, R6, List(3),
+		Mov, R8, Float(-2.5),
+		Mov, R9, String("hello, 世界"),
+		Push, String("hello, 世界"),
+		Mov, StackRelativeOffset{BasePointer, -1}, Integer(-300), // 定数は共有される
+		Push, *NewBPOffset(2),
+		Push, ProgramAbsoluteOffset(4),
+		Push, MemoryOffset(7),
+		Load, R7, MemoryRelativeOffset{R1, -2},
+		Syscall, Write, StdErr, R1,
+		Syscall, HostSystemCallBase + 1, R1, Null{},
+		Ret,
+	}
+	data, err := prog.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("BRBA\x00\x04"), data[:6])
+
+	var decoded Program
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	want := append(Program{}, prog...)
+	for i, obj := range want {
+		if offset, ok := obj.(*StackRelativeOffset); ok {
+			want[i] = *offset // ポインタは値として読み込まれる
+		}
+	}
+	assert.Equal(t, want, decoded)
+
+	// io経由
+	var buf bytes.Buffer
+	assert.Nil(t, EncodeProgram(&buf, prog))
+	decoded, err = DecodeProgram(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, want, decoded)
+}
+
+func TestProgram_UnmarshalBinary_Run(t *testing.T) {
+	data, err := Program{
+		DefLabel(0),
+		Mov, General1, Integer(1),
+		Add, General1, Integer(4),
+		Mov, ACM1, General1,
+		Ret,
+	}.MarshalBinary()
+	assert.Nil(t, err)
+	prog, err := DecodeProgram(bytes.NewReader(data))
+	assert.Nil(t, err)
+	rt := NewRuntime(10, 10)
+	rt.Load(prog)
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 5, rt.Status())
+}
+
+func TestProgram_UnmarshalBinary_Error(t *testing.T) {
+	data, err := Program{
+		DefLabel(0),
+		Mov, StackRelativeOffset{BasePointer, -1}, Integer(1),
+		Syscall, Write, StdOut, Character('a'),
+		Ret,
+	}.MarshalBinary()
+	assert.Nil(t, err)
+
+	// どこで切れていてもpanicせずにエラーになる
+	for i := 0; i < len(data); i++ {
+		var prog Program
+		assert.NotNil(t, prog.UnmarshalBinary(data[:i]), "length=%d", i)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		expect error
+	}{
+		{"bad magic", []byte("ELF\x7f\x00\x01\x00\x00"), ErrBadMagic},
+		{"unsupported version", []byte("BRBA\x00\x09\x00\x00"), ErrUnsupportedVersion},
+		{"trailing bytes", append(append([]byte{}, data...), 0), ErrCorrupt},
+		{"unknown tag", []byte("BRBA\x00\x04\x00\x01\xff"), ErrCorrupt},
+		{"invalid opcode", []byte("BRBA\x00\x04\x00\x01\x01\x7e"), ErrCorrupt},
+		{"invalid register", []byte("BRBA\x00\x04\x00\x01\x02\x7e"), ErrCorrupt},
+		{"invalid bool", []byte("BRBA\x00\x04\x01\x05\x02\x00"), ErrCorrupt},
+		{"non-constant in constant section", []byte("BRBA\x00\x04\x01\x01\x00\x00"), ErrCorrupt},
+		{"constant out of range", []byte("BRBA\x00\x04\x00\x01\x0f\x00"), ErrCorrupt},
+		{"invalid offset target", []byte("BRBA\x00\x04\x00\x01\x0a\x06\x02"), ErrCorrupt},
+		{"invalid utf-8 string", []byte("BRBA\x00\x04\x01\x12\x01\xff\x00"), ErrCorrupt},
+		{"huge count", []byte("BRBA\x00\x04\xff\xff\xff\xff\x0f"), ErrTruncated},
+		{"varint overflow", []byte("BRBA\x00\x04\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"), ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prog Program
+			assert.ErrorIs(t, prog.UnmarshalBinary(tt.data), tt.expect)
+		})
+	}
+}
+
+func TestProgram_MarshalBinary_Error(t *testing.T) {
+	_, err := Program{Push, ZeroFlag, nil}.MarshalBinary()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,43 @@
+package runtime
+
+import "fmt"
+
+// Constant 定数プールの番号
+type Constant int
+
+func (c Constant) Value() int {
+	return int(c)
+}
+func (c Constant) String() string {
+	return fmt.Sprintf("const(%d)", c.Value())
+}
+
+// ConstantPool プログラム中の文字列リテラルを1度だけ持つ
+type ConstantPool []Object
+
+func (p ConstantPool) Get(c Constant) (Object, error) {
+	if c < 0 || len(p) <= c.Value() {
+		return nil, fmt.Errorf("constant out of range: %v", c)
+	}
+	return p[c], nil
+}
+
+// internConstants 文字列リテラルを定数プールに移し, 番号で参照するように書き換える
+func internConstants(program Program) (Program, ConstantPool) {
+	var pool ConstantPool
+	index := map[Object]Constant{}
+	for pc, code := range program {
+		s, ok := code.(String)
+		if !ok {
+			continue
+		}
+		c, ok := index[s]
+		if !ok {
+			c = Constant(len(pool))
+			index[s] = c
+			pool = append(pool, s)
+		}
+		program[pc] = c
+	}
+	return program, pool
+}
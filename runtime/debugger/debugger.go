@@ -0,0 +1,269 @@
+// Package debugger runtime.Runtimeをgdbのようなコマンドで操作する.
+//
+//	break fib    fibの先頭にブレークポイントを置く, *12のようにpcでも指定できる
+//	step         命令を1つ実行する
+//	next         callなら戻ってくるまで実行する
+//	finish       今の関数から戻るまで実行する
+//	continue     ブレークポイントか終了まで実行する
+//	regs         レジスタを表示する
+//	stack        スタックを表示する
+//	frame        呼び出しの連なりを表示する
+//	print [bp-1] オペランドが指す値を表示する
+//
+// 表示はasmパッケージの逆アセンブルと同じ形式を使う.
+package debugger
+
+import (
+	"barba/runtime"
+	"barba/runtime/asm"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Debugger struct {
+	Prompt string // 空ならプロンプトを出さない
+
+	rt     *runtime.Runtime
+	prog   runtime.Program // 表示用, 定数は値に戻してある
+	labels map[string]runtime.Label
+	names  asm.Names
+	out    io.Writer
+}
+
+// New LoadとCollectLabelsを済ませたRuntimeを操作する, labelsはasm.Parseで得たラベル名
+func New(rt *runtime.Runtime, labels map[string]runtime.Label, out io.Writer) *Debugger {
+	prog := rt.Program()
+	consts := rt.Constants()
+	for pc, obj := range prog {
+		if c, ok := obj.(runtime.Constant); ok {
+			if v, err := consts.Get(c); err == nil {
+				prog[pc] = v
+			}
+		}
+	}
+	return &Debugger{
+		rt:     rt,
+		prog:   prog,
+		labels: labels,
+		names:  asm.LabelNames(labels),
+		out:    out,
+	}
+}
+
+// Run inから1行ずつコマンドを読んで実行する, quitか入力の終わりで止まる
+func (d *Debugger) Run(in io.Reader) error {
+	sc := bufio.NewScanner(in)
+	for {
+		if d.Prompt != "" {
+			fmt.Fprint(d.out, d.Prompt)
+		}
+		if !sc.Scan() {
+			return sc.Err()
+		}
+		quit, err := d.Exec(sc.Text())
+		if err != nil {
+			fmt.Fprintf(d.out, "error: %v\n", err)
+			var rerr *runtime.RuntimeError
+			if errors.As(err, &rerr) {
+				fmt.Fprint(d.out, rerr.Trace)
+			}
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec コマンドを1つ実行する
+func (d *Debugger) Exec(line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	cmd, args := fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch cmd {
+	case "break", "b":
+		return false, d.breakpoint(args)
+	case "delete", "d":
+		return false, d.delete(args)
+	case "step", "s":
+		return false, d.run(d.step)
+	case "next", "n":
+		return false, d.run(d.next)
+	case "finish":
+		return false, d.run(d.finish)
+	case "continue", "c":
+		return false, d.run(d.rt.RunUntilBreak)
+	case "regs":
+		d.regs()
+		return false, nil
+	case "stack":
+		d.stack()
+		return false, nil
+	case "frame", "bt":
+		d.frames()
+		return false, nil
+	case "print", "p":
+		return false, d.print(args)
+	case "quit", "q":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// breakPc ラベル名か*12のようなpcを命令の位置にする
+func (d *Debugger) breakPc(arg string) (int, error) {
+	if arg == "" {
+		return 0, fmt.Errorf("expect label or *pc")
+	}
+	if pc, ok := strings.CutPrefix(arg, "*"); ok {
+		return strconv.Atoi(pc)
+	}
+	obj, err := asm.ParseOperand(arg, d.labels)
+	if err != nil {
+		return 0, err
+	}
+	label, ok := obj.(runtime.Label)
+	if !ok {
+		return 0, fmt.Errorf("not a label: %s", arg)
+	}
+	return d.rt.LabelPc(label)
+}
+
+func (d *Debugger) breakpoint(arg string) error {
+	pc, err := d.breakPc(arg)
+	if err != nil {
+		return err
+	}
+	if err := d.rt.SetBreakpoint(pc); err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "breakpoint at %s\n", d.location(pc))
+	return nil
+}
+
+func (d *Debugger) delete(arg string) error {
+	pc, err := d.breakPc(arg)
+	if err != nil {
+		return err
+	}
+	d.rt.ClearBreakpoint(pc)
+	return nil
+}
+
+// run 実行して止まった場所を表示する
+func (d *Debugger) run(fn func() error) error {
+	if d.rt.Exited() {
+		return fmt.Errorf("program is not running")
+	}
+	err := fn()
+	if d.rt.Exited() {
+		fmt.Fprintf(d.out, "exited with status %d\n", d.rt.Status())
+	} else {
+		fmt.Fprintln(d.out, d.location(d.rt.Pc()))
+	}
+	return err
+}
+
+func (d *Debugger) step() error {
+	return d.rt.Step()
+}
+
+// next callは戻ってくるまで実行する, ブレークポイントでも止まる
+func (d *Debugger) next() error {
+	if d.prog[d.rt.Pc()] != runtime.Call {
+		return d.rt.Step()
+	}
+	return d.until(func(depth int) bool { return depth == 0 })
+}
+
+// finish 今の関数のretを実行するまで進める
+func (d *Debugger) finish() error {
+	return d.until(func(depth int) bool { return depth < 0 })
+}
+
+// until 実行したcallとretで深さを数え, doneになるまで進める
+func (d *Debugger) until(done func(depth int) bool) error {
+	depth := 0
+	for {
+		op := d.prog[d.rt.Pc()]
+		if err := d.rt.Step(); err != nil {
+			return err
+		}
+		switch op {
+		case runtime.Call:
+			depth++
+		case runtime.Ret:
+			depth--
+		}
+		if done(depth) || d.rt.Exited() || d.rt.HasBreakpoint(d.rt.Pc()) {
+			return nil
+		}
+	}
+}
+
+func (d *Debugger) regs() {
+	for i, v := range d.rt.Registers() {
+		fmt.Fprintf(d.out, "%s\t%s\n", runtime.Register(i), d.value(v))
+	}
+}
+
+func (d *Debugger) stack() {
+	for i, v := range d.rt.Stack() {
+		fmt.Fprintf(d.out, "[sp+%d]\t%s\n", i, d.value(v))
+	}
+}
+
+func (d *Debugger) frames() {
+	for i, f := range d.rt.Frames() {
+		fmt.Fprintf(d.out, "#%d %s\n", i, d.location(f.Pc))
+	}
+}
+
+func (d *Debugger) print(arg string) error {
+	obj, err := asm.ParseOperand(arg, d.labels)
+	if err != nil {
+		return err
+	}
+	v, err := d.rt.Inspect(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "%s = %s\n", arg, d.value(v))
+	return nil
+}
+
+// location pc=10 in fib: add r1 1
+func (d *Debugger) location(pc int) string {
+	if pc < 0 || len(d.prog) <= pc {
+		return fmt.Sprintf("pc=%d: out of program", pc)
+	}
+	fn := "?"
+	for i := pc; i >= 0; i-- {
+		if label, ok := d.prog[i].(runtime.DefLabel); ok {
+			fn = d.names.Name(runtime.Label(label.Value()))
+			break
+		}
+	}
+	line, err := asm.FormatInstruction(d.prog, pc, d.names)
+	if err != nil {
+		line = err.Error()
+	}
+	return fmt.Sprintf("pc=%d in %s: %s", pc, fn, line)
+}
+
+// value オペランドと同じ形式で値を表示する, 空なら-
+func (d *Debugger) value(v runtime.Object) string {
+	if v == nil {
+		return "-"
+	}
+	if s, err := asm.FormatOperand(v, d.names); err == nil {
+		return s
+	}
+	return v.String()
+}
@@ -0,0 +1,163 @@
+package debugger
+
+import (
+	"barba/runtime"
+	"barba/runtime/asm"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const incProgram = `
+inc:
+	push bp
+	mov bp sp
+	add r1 1
+	mov sp bp
+	pop bp
+	ret
+main:
+	push bp
+	mov bp sp
+	mov r1 0
+	push 5
+	call inc
+	call inc
+	pop r2
+	syscall write stdout "done\n"
+	mov sp bp
+	pop bp
+	ret
+`
+
+func newDebugger(t *testing.T, stdout *bytes.Buffer) (*Debugger, *bytes.Buffer) {
+	unit, err := asm.Parse(incProgram)
+	assert.Nil(t, err)
+	rt := runtime.NewRuntime(10, 10, runtime.WithStdout(stdout))
+	rt.Load(unit.Program)
+	assert.Nil(t, rt.CollectLabels())
+	var out bytes.Buffer
+	return New(rt, unit.Labels, &out), &out
+}
+
+func TestDebugger_Run(t *testing.T) {
+	var stdout bytes.Buffer
+	d, out := newDebugger(t, &stdout)
+	script := `break inc
+continue
+step
+step
+frame
+print [bp+1]
+print r1
+finish
+stack
+next
+next
+bogus
+delete inc
+continue
+step
+`
+	assert.Nil(t, d.Run(strings.NewReader(script)))
+	assert.Equal(t, "done\n", stdout.String())
+	assert.Equal(t, `breakpoint at pc=5 in inc: push bp
+pc=5 in inc: push bp
+pc=7 in inc: mov bp sp
+pc=10 in inc: add r1 1
+#0 pc=10 in inc: add r1 1
+#1 pc=32 in main: call inc
+#2 pc=3 in l_-1: exit
+[bp+1] = pc(32)
+r1 = 0
+pc=32 in main: call inc
+[sp+0]	5
+[sp+1]	0
+[sp+2]	pc(3)
+pc=5 in inc: push bp
+pc=7 in inc: mov bp sp
+error: unknown command: bogus
+exited with status 0
+error: program is not running
+`, out.String())
+}
+
+func TestDebugger_Next(t *testing.T) {
+	var stdout bytes.Buffer
+	d, out := newDebugger(t, &stdout)
+	// ブレークポイントがなければcallを飛び越える
+	assert.Nil(t, d.Run(strings.NewReader("break *30\ncontinue\nnext\nnext\nregs\nquit\nstep\n")))
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, []string{
+		"breakpoint at pc=30 in main: call inc",
+		"pc=30 in main: call inc",
+		"pc=32 in main: call inc",
+		"pc=34 in main: pop r2",
+		"pc\t34",
+	}, lines[:5])
+	assert.Contains(t, lines, "r1\t2")
+	assert.Contains(t, lines, "acm1\t-")
+	// quitの後は読まない
+	assert.Equal(t, "", lines[len(lines)-1])
+	assert.NotContains(t, out.String(), "exited")
+}
+
+// ラベルを位置にするだけではブレークポイントは変わらない
+func TestDebugger_Exec_BreakLabel(t *testing.T) {
+	d, _ := newDebugger(t, &bytes.Buffer{})
+	_, err := d.Exec("delete inc")
+	assert.Nil(t, err)
+	assert.Empty(t, d.rt.Breakpoints())
+	_, err = d.Exec("break inc")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5}, d.rt.Breakpoints())
+	_, err = d.Exec("delete inc")
+	assert.Nil(t, err)
+	assert.Empty(t, d.rt.Breakpoints())
+	_, err = d.Exec("delete nowhere")
+	assert.NotNil(t, err)
+	assert.Empty(t, d.rt.Breakpoints())
+}
+
+func TestDebugger_Exec_Error(t *testing.T) {
+	var stdout bytes.Buffer
+	d, _ := newDebugger(t, &stdout)
+	for _, line := range []string{"break", "break foo", "break r1", "break *2", "print [r1]", "print mem[99]"} {
+		_, err := d.Exec(line)
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestDebugger_Run_RuntimeError(t *testing.T) {
+	unit, err := asm.Parse(`
+divide:
+	push bp
+	mov bp sp
+	mov r2 1
+	div r2 r1
+	mov sp bp
+	pop bp
+	ret
+main:
+	push bp
+	mov bp sp
+	mov r1 0
+	call divide
+	mov sp bp
+	pop bp
+	ret
+`)
+	assert.Nil(t, err)
+	rt := runtime.NewRuntime(10, 10, runtime.WithDebugInfo(unit.DebugInfo("div.s")))
+	rt.Load(unit.Program)
+	assert.Nil(t, rt.CollectLabels())
+	var out bytes.Buffer
+	d := New(rt, unit.Labels, &out)
+	assert.Nil(t, d.Run(strings.NewReader("continue\n")))
+	assert.Equal(t, `pc=13 in divide: div r2 r1
+error: division by zero at pc=13 (Div): division by zero: r2, r1
+	at divide (div.s:6:2) pc=13
+	at main (div.s:14:2) pc=31
+`, out.String())
+}
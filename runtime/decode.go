@@ -0,0 +1,353 @@
+package runtime
+
+import "context"
+
+// operandKind 解読したオペランドの種類
+type operandKind uint8
+
+const (
+	operandOther     operandKind = iota // 速い経路では扱わない
+	operandRegister                     // pc以外のレジスタ
+	operandStack                        // [bp+n], [sp+n]
+	operandImmediate                    // 即値, 文字列は定数プールから取り出し済み
+)
+
+type operand struct {
+	kind operandKind
+	reg  Register // operandRegisterのレジスタ, operandStackの基準
+	off  int      // operandStackの距離
+	imm  Object   // operandImmediateの値
+}
+
+// instruction 解読した命令
+// 速い経路で実行できない形の命令はstepに任せるので, エラーの扱いはdoと変わらない
+type instruction struct {
+	op     Opcode
+	fast   bool // 速い経路で実行できる形か
+	args   [3]operand
+	target int // 飛び先の最初の命令, 飛ばない命令や解決できないときは-1
+	next   int // 次の命令, ラベルは読み飛ばし済み, なければ-1
+}
+
+// decode プログラムを命令の列にする, 添字はpcのまま使う
+// ラベルはCollectLabelsで集めたものを使うので, その後に呼ぶ
+func (r *Runtime) decode() {
+	code := make([]instruction, len(r.program))
+	for pc, obj := range r.program {
+		op, ok := obj.(Opcode)
+		if !ok {
+			continue
+		}
+		in := &code[pc]
+		in.op = op
+		in.target = -1
+		n := Operand(op)
+		if len(r.program) <= pc+n { // オペランドが足りない
+			continue
+		}
+		in.next = r.program.firstOpcode(pc + 1 + n)
+		for i := 0; i < n; i++ {
+			in.args[i] = r.decodeOperand(r.program[pc+1+i])
+		}
+		var label Object
+		switch op {
+		case Call, Jmp, Je, Jne:
+			label = r.program[pc+1]
+		case Beq, Bne, Blt, Ble, Bgt, Bge:
+			label = r.program[pc+3]
+		}
+		if label, ok := label.(Label); ok {
+			if dest, err := r.sym.Get(label); err == nil {
+				in.target = r.program.firstOpcode(dest.Value())
+			}
+		}
+		in.fast = in.isFast()
+	}
+	r.code = code
+}
+
+func (r *Runtime) decodeOperand(obj Object) operand {
+	switch obj := obj.(type) {
+	case Register:
+		// pcは実行中にレジスタへ書き戻さないので遅い経路で扱う
+		if obj < 0 || _reg_end <= obj || obj == ProgramCounter {
+			return operand{}
+		}
+		return operand{kind: operandRegister, reg: obj}
+	case StackRelativeOffset:
+		if obj.target != BasePointer && obj.target != StackPointer {
+			return operand{}
+		}
+		return operand{kind: operandStack, reg: obj.target, off: obj.relativeDistance}
+	case Integer, Float, Character, Bool, Null:
+		return operand{kind: operandImmediate, imm: obj}
+	case Constant:
+		v, err := r.consts.Get(obj)
+		if err != nil {
+			return operand{}
+		}
+		return operand{kind: operandImmediate, imm: v}
+	default:
+		return operand{}
+	}
+}
+
+// isFast オペランドの形が速い経路で扱えるものか
+func (in *instruction) isFast() bool {
+	value := func(a operand) bool { return a.kind != operandOther }
+	comparand := func(a operand) bool { return a.kind == operandRegister || a.kind == operandImmediate }
+	switch in.op {
+	case Exit, Ret:
+		return true
+	case Call, Jmp:
+		return in.target >= 0
+	case Je, Jne:
+		return in.target >= 0 && in.next >= 0
+	case Beq, Bne, Blt, Ble, Bgt, Bge:
+		return in.target >= 0 && in.next >= 0 && comparand(in.args[0]) && comparand(in.args[1])
+	case Mov:
+		dest := in.args[0].kind
+		return in.next >= 0 && (dest == operandRegister || dest == operandStack) && value(in.args[1])
+	case Push:
+		return in.next >= 0 && value(in.args[0])
+	case Pop:
+		return in.next >= 0 && in.args[0].kind == operandRegister
+	case Add, Sub, Mul, Div, Mod:
+		src := in.args[1].kind
+		return in.next >= 0 && in.args[0].kind == operandRegister && (src == operandRegister || src == operandImmediate)
+	case Eq, Ne, Lt, Le, Gt, Ge:
+		return in.next >= 0 && comparand(in.args[0]) && comparand(in.args[1])
+	default:
+		return false
+	}
+}
+
+// runDecoded 解読した命令を実行する
+// 速い経路で扱えない命令や失敗しそうな命令は, pcを書き戻してstepで実行する
+func (r *Runtime) runDecoded(ctx context.Context) error {
+	r.decode()
+	code := r.code
+	pc := r.pc()
+	for !r.mustExit() {
+		if r.executed%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				r.setPc(pc)
+				return &HaltError{Pc: pc, Executed: r.executed, Err: err}
+			}
+		}
+		if 0 < r.fuel && r.fuel <= r.executed {
+			r.setPc(pc)
+			return &HaltError{Pc: pc, Executed: r.executed, Err: ErrFuelExhausted}
+		}
+		if in := &code[pc]; in.fast {
+			at := pc
+			if r.exec(in, &pc) {
+				r.executed++
+				if r.sched != nil {
+					r.setPc(pc)
+					if err := r.schedule(); err != nil {
+						return r.fail(at, in.op, err)
+					}
+					pc = r.pc()
+				}
+				continue
+			}
+		}
+		r.setPc(pc)
+		if err := r.step(); err != nil {
+			return err
+		}
+		pc = r.pc()
+	}
+	r.setPc(pc)
+	return nil
+}
+
+// exec 命令を1つ実行してpcを進める
+// doと同じ結果になることが確かめられないときは, 何も変えずにfalseを返す
+func (r *Runtime) exec(in *instruction, pc *int) bool {
+	switch in.op {
+	case Exit:
+		r.reg[ExitFlag] = True
+		return true
+	case Call:
+		sp := r.sp() - 1
+		if sp < 0 || len(r.stack) <= sp {
+			return false
+		}
+		r.stack[sp] = ProgramAbsoluteOffset(*pc + 1 + Operand(Call))
+		r.setSp(sp)
+		*pc = in.target
+	case Ret:
+		sp := r.sp()
+		if sp < 0 || len(r.stack)-1 <= sp {
+			return false
+		}
+		ret, ok := r.stack[sp].(ProgramAbsoluteOffset)
+		if !ok {
+			return false
+		}
+		next := r.program.firstOpcode(ret.Value())
+		if next < 0 {
+			return false
+		}
+		r.stack[sp] = nil
+		r.setSp(sp + 1)
+		*pc = next
+	case Jmp:
+		*pc = in.target
+	case Je:
+		if r.reg[ZeroFlag] == True {
+			*pc = in.target
+		} else {
+			*pc = in.next
+		}
+	case Jne:
+		if r.reg[ZeroFlag] == False {
+			*pc = in.target
+		} else {
+			*pc = in.next
+		}
+	case Mov:
+		v, ok := r.fetch(&in.args[1])
+		if !ok || !r.put(&in.args[0], v) {
+			return false
+		}
+		*pc = in.next
+	case Push:
+		v, ok := r.fetch(&in.args[0])
+		sp := r.sp() - 1
+		if !ok || v == nil || sp < 0 || len(r.stack) <= sp {
+			return false
+		}
+		r.stack[sp] = v
+		r.setSp(sp)
+		*pc = in.next
+	case Pop:
+		sp := r.sp()
+		if sp < 0 || len(r.stack)-1 <= sp {
+			return false
+		}
+		v := r.stack[sp]
+		if !writable(in.args[0].reg, v) {
+			return false
+		}
+		r.stack[sp] = nil
+		r.setSp(sp + 1)
+		r.reg[in.args[0].reg] = v
+		*pc = in.next
+	case Add, Sub, Mul, Div, Mod: // 整数どうしだけ
+		lhs, ok := r.reg[in.args[0].reg].(Integer)
+		if !ok {
+			return false
+		}
+		v, _ := r.fetch(&in.args[1])
+		rhs, ok := v.(Integer)
+		if !ok {
+			return false
+		}
+		switch in.op {
+		case Add:
+			lhs += rhs
+		case Sub:
+			lhs -= rhs
+		case Mul:
+			lhs *= rhs
+		case Div:
+			if rhs == 0 {
+				return false
+			}
+			lhs /= rhs
+		case Mod:
+			if rhs == 0 {
+				return false
+			}
+			lhs %= rhs
+		}
+		r.reg[in.args[0].reg] = lhs
+		*pc = in.next
+	case Eq, Ne, Lt, Le, Gt, Ge:
+		lhs, rhs, ok := r.fetchPair(in)
+		if !ok {
+			return false
+		}
+		r.reg[ZeroFlag] = Bool(compare(in.op, lhs, rhs))
+		*pc = in.next
+	case Beq, Bne, Blt, Ble, Bgt, Bge:
+		lhs, rhs, ok := r.fetchPair(in)
+		if !ok {
+			return false
+		}
+		if compare(in.op, lhs, rhs) {
+			*pc = in.target
+		} else {
+			*pc = in.next
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// fetch オペランドの値を読む, 空ならnilのままで, 読めない位置ならfalse
+func (r *Runtime) fetch(a *operand) (Object, bool) {
+	switch a.kind {
+	case operandRegister:
+		return r.reg[a.reg], true
+	case operandStack:
+		base, ok := r.reg[a.reg].(Integer)
+		i := int(base) + a.off
+		if !ok || i < 0 || len(r.stack) <= i {
+			return nil, false
+		}
+		return r.stack[i], true
+	case operandImmediate:
+		return a.imm, true
+	default:
+		return nil, false
+	}
+}
+
+// fetchPair 比較命令の左辺と右辺, どちらも空でないこと
+func (r *Runtime) fetchPair(in *instruction) (Object, Object, bool) {
+	lhs, ok := r.fetch(&in.args[0])
+	if !ok || lhs == nil {
+		return nil, nil, false
+	}
+	rhs, ok := r.fetch(&in.args[1])
+	if !ok || rhs == nil {
+		return nil, nil, false
+	}
+	return lhs, rhs, true
+}
+
+// put オペランドの位置に書き込む, 書けない位置ならfalse
+func (r *Runtime) put(a *operand, v Object) bool {
+	switch a.kind {
+	case operandRegister:
+		if !writable(a.reg, v) {
+			return false
+		}
+		r.reg[a.reg] = v
+		return true
+	case operandStack:
+		base, ok := r.reg[a.reg].(Integer)
+		i := int(base) + a.off
+		if !ok || i < 0 || len(r.stack) <= i {
+			return false
+		}
+		r.stack[i] = v
+		return true
+	default:
+		return false
+	}
+}
+
+// writable regにvを書いてよいか, bpとspには整数しか置かない
+func writable(reg Register, v Object) bool {
+	if reg != BasePointer && reg != StackPointer {
+		return true
+	}
+	_, ok := v.(Integer)
+	return ok
+}
@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestRuntime_decode(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0), // 4
+		Mov, R1, String("a"),
+		DefLabel(1),
+		Jmp, Label(2), // 9
+		DefLabel(2),
+		Push, ProgramCounter, // 12
+		Call, Label(3), // 未定義
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	rt.decode()
+	// 文字列は定数プールから取り出し済み
+	mov := rt.code[5]
+	assert.Equal(t, operand{kind: operandImmediate, imm: String("a")}, mov.args[1])
+	assert.Equal(t, 9, mov.next)
+	assert.True(t, mov.fast)
+	// 飛び先はラベルの後の最初の命令
+	assert.Equal(t, 12, rt.code[9].target)
+	// pcを読む命令と解決できない飛び先は遅い経路で扱う
+	assert.False(t, rt.code[12].fast)
+	assert.Equal(t, -1, rt.code[14].target)
+	assert.False(t, rt.code[14].fast)
+}
+
+// 解読して実行しても, 1命令ずつ実行したときと同じ状態になる
+func TestRuntime_Run_Decoded(t *testing.T) {
+	tests := []struct {
+		name string
+		prog Program
+		fuel int
+	}{
+		{"fibonacci", fibonacciProgram(10), 0},
+		{"fizzbuzz", fizzBuzzProgram(), 0},
+		{"runtime error", recursiveProgram(), 0},
+		{"fuel", loopProgram(), 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fastOut, slowOut bytes.Buffer
+			fast := NewRuntime(1000, 10, WithStdout(&fastOut), WithFuel(tt.fuel))
+			slow := NewRuntime(1000, 10, WithStdout(&slowOut), WithFuel(tt.fuel))
+			for _, rt := range []*Runtime{fast, slow} {
+				rt.Load(tt.prog)
+				assert.Nil(t, rt.CollectLabels())
+			}
+			fastErr := fast.Run()
+			slowErr := slow.RunUntilBreak() // ブレークポイントがなければstepで最後まで実行する
+			assert.Equal(t, slowErr, fastErr)
+			assert.Equal(t, slowOut.String(), fastOut.String())
+			assert.Equal(t, slow.Registers(), fast.Registers())
+			assert.Equal(t, slow.stack, fast.stack)
+			assert.Equal(t, slow.Executed(), fast.Executed())
+		})
+	}
+}
+
+func TestRuntime_Run_Decoded_Fallback(t *testing.T) {
+	// 速い経路で扱えない命令が混ざっていても続けて実行できる
+	var buf bytes.Buffer
+	rt := NewRuntime(10, 10, WithStdout(&buf))
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Float(1.5),
+		Add, R1, Float(1),
+		Mov, R2, Integer(2),
+		Mul, R2, Integer(3),
+		Syscall, Write, StdOut, R1,
+		Mov, R3, Integer(1),
+		Div, R3, Integer(0),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	var rerr *RuntimeError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, DivisionByZero, rerr.Kind)
+	assert.Equal(t, 24, rt.Pc())
+	assert.Equal(t, "2.5", buf.String())
+	assert.Equal(t, Integer(6), rt.reg[R2])
+}
+
+func benchmarkRun(b *testing.B, prog Program, run func(*Runtime) error) {
+	for i := 0; i < b.N; i++ {
+		rt := NewRuntime(1000, 10, WithStdout(io.Discard))
+		rt.Load(prog)
+		if err := rt.CollectLabels(); err != nil {
+			b.Fatal(err)
+		}
+		if err := run(rt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRuntime_Run_* は解読して実行する, BenchmarkRuntime_Step_* は1命令ずつstepで実行する
+func BenchmarkRuntime_Run_Fibonacci(b *testing.B) {
+	benchmarkRun(b, fibonacciProgram(100), (*Runtime).Run)
+}
+
+func BenchmarkRuntime_Step_Fibonacci(b *testing.B) {
+	benchmarkRun(b, fibonacciProgram(100), (*Runtime).RunUntilBreak)
+}
+
+func BenchmarkRuntime_Run_FizzBuzz(b *testing.B) {
+	benchmarkRun(b, fizzBuzzProgram(), (*Runtime).Run)
+}
+
+func BenchmarkRuntime_Step_FizzBuzz(b *testing.B) {
+	benchmarkRun(b, fizzBuzzProgram(), (*Runtime).RunUntilBreak)
+}
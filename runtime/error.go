@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind 実行時エラーの種類
+type ErrorKind int
+
+const (
+	BadOperand        ErrorKind = iota // オペランドの型や値が命令に合わない
+	StackOverflow                      // pushでスタックが溢れた
+	StackUnderflow                     // 空のスタックからpopした
+	StackOutOfRange                    // [bp-1]のような位置がスタックの外を指している
+	PcOutOfRange                       // pcがプログラムの外に出た
+	DivisionByZero                     // 0で割った
+	UnknownSystemCall                  // 組み込みにも登録したホスト関数にもないシステムコール
+	SystemCallFailed                   // システムコールの中で失敗した
+	Deadlock                           // どのスレッドもJoinで待っていて進めない
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case BadOperand:
+		return "bad operand"
+	case StackOverflow:
+		return "stack overflow"
+	case StackUnderflow:
+		return "stack underflow"
+	case StackOutOfRange:
+		return "stack out of range"
+	case PcOutOfRange:
+		return "pc out of range"
+	case DivisionByZero:
+		return "division by zero"
+	case UnknownSystemCall:
+		return "unknown system call"
+	case SystemCallFailed:
+		return "system call failed"
+	case Deadlock:
+		return "deadlock"
+	default:
+		return fmt.Sprintf("error(%d)", int(k))
+	}
+}
+
+// RuntimeError 命令の実行に失敗した
+type RuntimeError struct {
+	Kind      ErrorKind
+	Pc        int        // 失敗した命令の位置
+	Opcode    Opcode     // 失敗した命令
+	Registers []Object   // 失敗したときのレジスタの写し, pcは失敗した命令を指す
+	Trace     StackTrace // 失敗したときの呼び出しの連なり
+	Err       error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%v at pc=%d (%v): %v", e.Kind, e.Pc, e.Opcode, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// kindError 種類の決まったエラー, stepでRuntimeErrorにする
+type kindError struct {
+	kind ErrorKind
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func errorf(kind ErrorKind, format string, args ...any) error {
+	return &kindError{kind, fmt.Sprintf(format, args...)}
+}
+
+// fail 命令opの実行に失敗したことをRuntimeErrorにする
+// pcは失敗した命令に戻しておく
+func (r *Runtime) fail(pc int, op Opcode, err error) error {
+	var halt *HaltError
+	if errors.As(err, &halt) {
+		return err
+	}
+	kind := BadOperand
+	var ke *kindError
+	switch {
+	case errors.As(err, &ke):
+		kind = ke.kind
+	case errors.Is(err, ErrUnknownSystemCall):
+		kind = UnknownSystemCall
+	case op == Syscall:
+		kind = SystemCallFailed
+	}
+	r.setPc(pc)
+	return &RuntimeError{
+		Kind:      kind,
+		Pc:        pc,
+		Opcode:    op,
+		Registers: append([]Object{}, r.reg...),
+		Trace:     r.StackTrace(),
+		Err:       err,
+	}
+}
@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuntime_Run_RuntimeError(t *testing.T) {
+	// mainの最初の命令はpc=5
+	tests := []struct {
+		name      string
+		stackSize int
+		program   Program
+		kind      ErrorKind
+		pc        int
+		opcode    Opcode
+	}{
+		{
+			"stack overflow", 3,
+			Program{DefLabel(0), Push, Integer(1), Push, Integer(2), Ret},
+			StackOverflow, 7, Push,
+		},
+		{
+			"stack underflow", 10,
+			Program{DefLabel(0), Pop, R1, Pop, R1, Ret},
+			StackUnderflow, 7, Pop,
+		},
+		{
+			"stack out of range", 10,
+			Program{DefLabel(0), Mov, R1, *NewStackRelativeOffset(StackPointer, 99), Ret},
+			StackOutOfRange, 5, Mov,
+		},
+		{
+			"truncated operand", 10,
+			Program{DefLabel(0), Mov, R1},
+			PcOutOfRange, 5, Mov,
+		},
+		{
+			"pc past the end", 10,
+			Program{DefLabel(0), Mov, R1, Integer(1)},
+			PcOutOfRange, 5, Mov,
+		},
+		{
+			"invalid register", 10,
+			Program{DefLabel(0), Mov, Register(99), Integer(1), Ret},
+			BadOperand, 5, Mov,
+		},
+		{
+			"write empty register", 10,
+			Program{DefLabel(0), Syscall, Write, StdOut, R3, Ret},
+			BadOperand, 5, Syscall,
+		},
+		{
+			"write to non standard io", 10,
+			Program{DefLabel(0), Syscall, Write, Integer(1), Character('a'), Ret},
+			BadOperand, 5, Syscall,
+		},
+		{
+			"division by zero", 10,
+			Program{DefLabel(0), Mov, R1, Integer(1), Div, R1, Integer(0), Ret},
+			DivisionByZero, 8, Div,
+		},
+		{
+			"add empty registers", 10,
+			Program{DefLabel(0), Add, R1, R2, Ret},
+			BadOperand, 5, Add,
+		},
+		{
+			"empty bp", 10,
+			Program{DefLabel(0), Mov, BasePointer, R1, Mov, R2, *NewBPOffset(0), Ret},
+			BadOperand, 5, Mov,
+		},
+		{
+			"empty sp", 10,
+			Program{DefLabel(0), Mov, StackPointer, R1, Push, Integer(1), Ret},
+			BadOperand, 5, Mov,
+		},
+		{
+			"pop non integer into sp", 10,
+			Program{DefLabel(0), Push, Character('a'), Pop, StackPointer, Ret},
+			BadOperand, 7, Pop,
+		},
+		{
+			"unknown system call", 10,
+			Program{DefLabel(0), Syscall, SystemCall(300), Null{}, Null{}, Ret},
+			UnknownSystemCall, 5, Syscall,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rt := NewRuntime(tt.stackSize, 10, WithStdout(&buf))
+			rt.Load(tt.program)
+			assert.Nil(t, rt.CollectLabels())
+			var err error
+			assert.NotPanics(t, func() { err = rt.Run() })
+			var rerr *RuntimeError
+			if !assert.True(t, errors.As(err, &rerr), "%v", err) {
+				return
+			}
+			assert.Equal(t, tt.kind, rerr.Kind)
+			assert.Equal(t, tt.pc, rerr.Pc)
+			assert.Equal(t, tt.opcode, rerr.Opcode)
+			assert.Equal(t, tt.pc, rerr.Registers[ProgramCounter].Value())
+			assert.Equal(t, tt.pc, rt.Pc())
+		})
+	}
+}
+
+func TestRuntime_Step_RuntimeError_Pointers(t *testing.T) {
+	program := Program{DefLabel(0), Mov, BasePointer, R1, Ret}
+	rt := NewRuntime(10, 10)
+	rt.Load(program)
+	assert.Nil(t, rt.CollectLabels())
+	var err error
+	for err == nil && rt.Pc() != 5 {
+		err = rt.Step()
+	}
+	assert.Nil(t, err)
+	assert.NotPanics(t, func() { err = rt.Step() })
+	var rerr *RuntimeError
+	if assert.True(t, errors.As(err, &rerr), "%v", err) {
+		assert.Equal(t, BadOperand, rerr.Kind)
+		assert.Equal(t, 5, rerr.Pc)
+	}
+	assert.IsType(t, Integer(0), rt.Register(BasePointer)) // 書き込む前に戻す
+
+	// 整数でないbpやspが入っていてもpanicしない
+	for _, tt := range []struct {
+		reg     Register
+		program Program
+	}{
+		{BasePointer, Program{DefLabel(0), Mov, R1, *NewBPOffset(0), Ret}},
+		{StackPointer, Program{DefLabel(0), Push, Integer(1), Ret}},
+		{StackPointer, Program{DefLabel(0), Mov, *NewStackRelativeOffset(StackPointer, 0), Integer(1), Ret}},
+	} {
+		rt := NewRuntime(10, 10)
+		rt.Load(tt.program)
+		assert.Nil(t, rt.CollectLabels())
+		for err = nil; err == nil && rt.Pc() != 5; {
+			err = rt.Step()
+		}
+		rt.reg[tt.reg] = Null{}
+		assert.NotPanics(t, func() {
+			err = rt.Continue()
+			rt.StackTrace()
+		})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRuntimeError_Unwrap(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{DefLabel(0), Syscall, SystemCall(300), Null{}, Null{}, Ret})
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	assert.ErrorIs(t, err, ErrUnknownSystemCall)
+	assert.Contains(t, err.Error(), "unknown system call at pc=5 (Syscall)")
+
+	// 燃料切れはRuntimeErrorにしない
+	rt = NewRuntime(10, 10, WithFuel(10))
+	rt.Load(loopProgram())
+	assert.Nil(t, rt.CollectLabels())
+	err = rt.Run()
+	var halt *HaltError
+	assert.True(t, errors.As(err, &halt))
+	var rerr *RuntimeError
+	assert.False(t, errors.As(err, &rerr))
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"sort"
+	"time"
+)
+
+// GCStats ガベージコレクタの統計
+type GCStats struct {
+	Collections int           // 実行回数
+	Freed       int           // 解放した領域の数
+	FreedCells  int           // 解放したセルの数
+	LastPause   time.Duration // 直近の停止時間
+	TotalPause  time.Duration // 停止時間の合計
+}
+
+// GC マークアンドスイープでヒープを回収する
+// レジスタとスタックの生きている部分(sp以降), ヒープの外のメモリ(大域変数)を根として, Listから辿れない領域を解放する
+// スレッドがあれば, 止まっているスレッドのものと終わったスレッドの戻り値も根にする
+func (r *Runtime) GC() {
+	start := time.Now()
+	h := r.getHeap()
+
+	// 確保済み領域を先頭順に並べて, 途中を指すListからも領域を探せるようにする
+	starts := make([]int, 0, len(h.used))
+	for addr := range h.used {
+		starts = append(starts, addr)
+	}
+	sort.Ints(starts)
+	blockOf := func(addr int) (int, bool) {
+		i := sort.Search(len(starts), func(i int) bool { return starts[i] > addr }) - 1
+		if i < 0 || starts[i]+h.used[starts[i]] <= addr {
+			return 0, false
+		}
+		return starts[i], true
+	}
+
+	// マーク
+	marked := map[int]bool{}
+	var work []int
+	visit := func(obj Object) {
+		ref, ok := obj.(List)
+		if !ok {
+			return
+		}
+		addr, ok := blockOf(ref.Value())
+		if !ok || marked[addr] {
+			return
+		}
+		marked[addr] = true
+		work = append(work, addr)
+	}
+	for _, root := range r.roots() {
+		for _, obj := range root.reg {
+			visit(obj)
+		}
+		for sp := max(root.reg[StackPointer].Value(), 0); sp < len(root.stack); sp++ {
+			visit(root.stack[sp])
+		}
+	}
+	for addr, obj := range r.mem {
+		if addr < h.base || h.base+h.size <= addr {
+			visit(obj)
+		}
+	}
+	if r.sched != nil { // Joinで受け取られるのを待っている戻り値
+		for _, obj := range r.sched.done {
+			visit(obj)
+		}
+	}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, obj := range r.mem[addr : addr+h.used[addr]] {
+			visit(obj)
+		}
+	}
+
+	// スイープ
+	for _, addr := range starts {
+		if marked[addr] {
+			continue
+		}
+		size, _ := h.Free(addr)
+		for i := addr; i < addr+size; i++ {
+			r.mem.Delete(MemoryOffset(i))
+		}
+		r.gcStats.Freed++
+		r.gcStats.FreedCells += size
+	}
+
+	r.gcStats.Collections++
+	r.gcStats.LastPause = time.Since(start)
+	r.gcStats.TotalPause += r.gcStats.LastPause
+}
+
+// GCStats ガベージコレクタの統計
+func (r *Runtime) GCStats() GCStats {
+	return r.gcStats
+}
+
+// roots GCの根にするレジスタとスタック, 実行中でないスレッドのものも含む
+func (r *Runtime) roots() []*thread {
+	if r.sched == nil {
+		return []*thread{{reg: r.reg, stack: r.stack}}
+	}
+	return r.sched.queue
+}
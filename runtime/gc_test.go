@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuntime_GC(t *testing.T) {
+	rt := NewRuntime(10, 12)
+	rt.Load(Program{
+		DefLabel(0),
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		// a -> b と繋ぐ
+		Syscall, Alloc, R1, Integer(2), // a: 0-1
+		Syscall, Alloc, R2, Integer(2), // b: 2-3
+		Store, MemoryRelativeOffset{R1, 1}, R2,
+		// c はどこからも参照されない
+		Syscall, Alloc, R3, Integer(3), // c: 4-6
+		// d はスタックからだけ参照される
+		Syscall, Alloc, R4, Integer(2), // d: 7-8
+		Push, R4,
+		// e は途中を指す参照からだけ参照される
+		Syscall, Alloc, R5, Integer(3), // e: 9-11
+		Add, R5, Integer(2),
+		Mov, R2, Null{},
+		Mov, R3, Null{},
+		Mov, R4, Null{},
+		Syscall, GarbageCollect, Null{}, Null{},
+		Mov, StackPointer, BasePointer,
+		Pop, BasePointer,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	stats := rt.GCStats()
+	assert.Equal(t, 1, stats.Collections)
+	assert.Equal(t, 1, stats.Freed) // cだけ
+	assert.Equal(t, 3, stats.FreedCells)
+	assert.Equal(t, stats.LastPause, stats.TotalPause)
+	assert.Equal(t, map[int]int{0: 2, 2: 2, 7: 2, 9: 3}, rt.heap.used)
+	assert.Equal(t, List(2), rt.mem[1])
+
+	// mainから戻ったのでスタックの参照は死んでいる, aとeはレジスタに残っている
+	rt.GC()
+	assert.Equal(t, 2, rt.GCStats().Collections)
+	assert.Equal(t, map[int]int{0: 2, 2: 2, 9: 3}, rt.heap.used)
+}
+
+// 大域変数からだけ指される領域は回収しない
+func TestRuntime_GC_Globals(t *testing.T) {
+	rt := NewRuntime(10, 4, WithHeapBase(1))
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Alloc, R1, Integer(2),
+		Store, MemoryRelativeOffset{R1, 1}, Integer(42),
+		Store, MemoryOffset(0), R1,
+		Mov, R1, Null{},
+		Syscall, GarbageCollect, Null{}, Null{},
+		Load, R2, MemoryOffset(0),
+		Load, R3, MemoryRelativeOffset{R2, 1},
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 0, rt.GCStats().Freed)
+	assert.Equal(t, List(1), rt.reg[R2])
+	assert.Equal(t, Integer(42), rt.reg[R3])
+
+	// 大域変数から外すと回収される
+	assert.Nil(t, rt.mem.Set(MemoryOffset(0), Null{}))
+	rt.reg[R2] = Null{}
+	rt.GC()
+	assert.Equal(t, 1, rt.GCStats().Freed)
+	assert.Empty(t, rt.heap.used)
+}
+
+func TestRuntime_GC_OnAllocFailure(t *testing.T) {
+	// 4セルのヒープで2セルずつ10回確保しても, 使い捨てなので足りなくならない
+	rt := NewRuntime(10, 4)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R2, Integer(0),
+		DefLabel(1),
+		Syscall, Alloc, R1, Integer(2),
+		Store, MemoryRelativeOffset{R1, 0}, R2,
+		Add, R2, Integer(1),
+		Blt, R2, Integer(10), Label(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(10), rt.reg[R2])
+	assert.Less(t, 0, rt.GCStats().Collections)
+	assert.Equal(t, 2, rt.HeapStats().Blocks) // 最後のものと, まだ回収されていない1つ前のもの
+
+	// 全部生きていれば回収しても足りない
+	rt = NewRuntime(10, 4)
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Alloc, R1, Integer(2),
+		Syscall, Alloc, R2, Integer(2),
+		Syscall, Alloc, R3, Integer(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+	assert.Equal(t, 1, rt.GCStats().Collections)
+	assert.Equal(t, 0, rt.GCStats().Freed)
+}
@@ -0,0 +1,83 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrFuelExhausted = errors.New("fuel exhausted")
+
+// HaltError 実行を途中で打ち切った
+// ErrはErrFuelExhaustedかcontextのエラー
+type HaltError struct {
+	Pc       int // 次に実行するはずだった命令
+	Executed int // 打ち切るまでに実行した命令の数
+	Err      error
+}
+
+func (e *HaltError) Error() string {
+	return fmt.Sprintf("halted at pc=%d after %d instructions: %v", e.Pc, e.Executed, e.Err)
+}
+
+func (e *HaltError) Unwrap() error {
+	return e.Err
+}
+
+// WithFuel 1回の実行で使える命令の数, 0なら制限しない
+func WithFuel(n int) Option {
+	return func(r *Runtime) {
+		r.fuel = n
+	}
+}
+
+// contextCheckInterval この数の命令ごとにcontextを確かめる
+const contextCheckInterval = 1024
+
+// RunContext ctxが終わるかfuelを使い切るまで実行する, 打ち切ったら*HaltErrorを返す
+func (r *Runtime) RunContext(ctx context.Context) error {
+	if err := r.start(); err != nil {
+		return err
+	}
+	return r.run(ctx)
+}
+
+// Continue 打ち切ったところから最後まで実行する
+func (r *Runtime) Continue() error {
+	return r.ContinueContext(context.Background())
+}
+
+// ContinueContext 打ち切ったところやRestoreしたところから続ける, 始める前ならRunContextと同じ
+// fuelはここから数え直す
+func (r *Runtime) ContinueContext(ctx context.Context) error {
+	if !r.started {
+		return r.RunContext(ctx)
+	}
+	if r.Exited() {
+		return fmt.Errorf("program has already exited")
+	}
+	r.executed = 0
+	return r.run(ctx)
+}
+
+func (r *Runtime) run(ctx context.Context) error {
+	if r.trace == nil && r.prof == nil { // 1命令ずつ見る必要がなければ解読してから実行する
+		return r.runDecoded(ctx)
+	}
+	for !r.mustExit() {
+		if r.executed%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return &HaltError{Pc: r.pc(), Executed: r.executed, Err: err}
+			}
+		}
+		if err := r.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Executed RunかContinueを始めてから実行した命令の数
+func (r *Runtime) Executed() int {
+	return r.executed
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// loopProgram 終わらないプログラム
+func loopProgram() Program {
+	return Program{
+		DefLabel(0),
+		Mov, R1, Integer(0),
+		DefLabel(1),
+		Add, R1, Integer(1),
+		Jmp, Label(1),
+	}
+}
+
+func TestRuntime_Run_Fuel(t *testing.T) {
+	rt := NewRuntime(10, 10, WithFuel(100))
+	rt.Load(loopProgram())
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	assert.ErrorIs(t, err, ErrFuelExhausted)
+	var halt *HaltError
+	assert.True(t, errors.As(err, &halt))
+	assert.Equal(t, 100, halt.Executed)
+	assert.Equal(t, 100, rt.Executed())
+	// call, mov の後は add, jmp を繰り返す
+	assert.Equal(t, 9, halt.Pc)
+	assert.Equal(t, Integer(49), rt.reg[R1])
+
+	// 足りていれば最後まで実行できる
+	rt = NewRuntime(10, 10, WithFuel(5))
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Integer(1),
+		Add, R1, Integer(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 5, rt.Executed())
+	// 実行し直すと先頭から数え直すので, 前の分と合わせてfuelを超えることはない
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 5, rt.Executed())
+	assert.Equal(t, Integer(2), rt.reg[R1])
+}
+
+func TestRuntime_RunContext(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(loopProgram())
+	assert.Nil(t, rt.CollectLabels())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := rt.RunContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var halt *HaltError
+	assert.True(t, errors.As(err, &halt))
+	assert.Equal(t, rt.Executed(), halt.Executed)
+	assert.Greater(t, halt.Executed, 0)
+	assert.Contains(t, []int{9, 12}, halt.Pc)
+
+	// 始める前に終わっていれば何も実行しない
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = rt.RunContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, rt.Executed())
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+)
+
+// block メモリ上の連続した領域
+type block struct {
+	addr int
+	size int
+}
+
+// Heap Memoryのbaseからsize個分を空きリストで管理する
+type Heap struct {
+	base int
+	size int
+	free []block     // アドレス順, 隣り合う空き領域は常に結合されている
+	used map[int]int // 確保済み領域の先頭: 大きさ
+}
+
+func NewHeap(size int) *Heap {
+	return NewHeapAt(0, size)
+}
+
+// NewHeapAt mem[base]からsize個分を管理するヒープ, base より前は使わない
+func NewHeapAt(base, size int) *Heap {
+	h := &Heap{
+		base: base,
+		size: size,
+		free: nil,
+		used: make(map[int]int),
+	}
+	if size > 0 {
+		h.free = append(h.free, block{base, size})
+	}
+	return h
+}
+
+// Alloc size個分の領域を確保して先頭を返す, 最初に見つかった空き領域を使う
+func (h *Heap) Alloc(size int) (int, error) {
+	if size <= 0 {
+		return 0, fmt.Errorf("heap: invalid alloc size: %d", size)
+	}
+	for i, b := range h.free {
+		if b.size < size {
+			continue
+		}
+		if b.size == size {
+			h.free = append(h.free[:i], h.free[i+1:]...)
+		} else {
+			h.free[i] = block{b.addr + size, b.size - size}
+		}
+		h.used[b.addr] = size
+		return b.addr, nil
+	}
+	return 0, fmt.Errorf("heap: out of memory: want=%d, largest free=%d", size, h.Stats().LargestFree)
+}
+
+// Free 確保した領域を解放し, 前後の空き領域と結合する
+func (h *Heap) Free(addr int) (int, error) {
+	size, ok := h.used[addr]
+	if !ok {
+		return 0, fmt.Errorf("heap: free of unallocated address: %d", addr)
+	}
+	delete(h.used, addr)
+
+	i := sort.Search(len(h.free), func(i int) bool { return h.free[i].addr > addr })
+	h.free = append(h.free, block{})
+	copy(h.free[i+1:], h.free[i:])
+	h.free[i] = block{addr, size}
+	// 後ろと結合
+	if i+1 < len(h.free) && h.free[i].addr+h.free[i].size == h.free[i+1].addr {
+		h.free[i].size += h.free[i+1].size
+		h.free = append(h.free[:i+1], h.free[i+2:]...)
+	}
+	// 前と結合
+	if 0 < i && h.free[i-1].addr+h.free[i-1].size == h.free[i].addr {
+		h.free[i-1].size += h.free[i].size
+		h.free = append(h.free[:i], h.free[i+1:]...)
+	}
+	return size, nil
+}
+
+// HeapStats ヒープの使用状況
+type HeapStats struct {
+	Size          int     // 管理しているセルの数
+	Used          int     // 確保済みのセルの数
+	Free          int     // 空いているセルの数
+	Blocks        int     // 確保済み領域の数
+	FreeBlocks    int     // 空き領域の数
+	LargestFree   int     // 一度に確保できる最大のセル数
+	Fragmentation float64 // 1 - LargestFree/Free, 空きが1つにまとまっていれば0
+}
+
+func (h *Heap) Stats() HeapStats {
+	stats := HeapStats{
+		Size:       h.size,
+		Blocks:     len(h.used),
+		FreeBlocks: len(h.free),
+	}
+	for _, b := range h.free {
+		stats.Free += b.size
+		if b.size > stats.LargestFree {
+			stats.LargestFree = b.size
+		}
+	}
+	stats.Used = h.size - stats.Free
+	if stats.Free > 0 {
+		stats.Fragmentation = 1 - float64(stats.LargestFree)/float64(stats.Free)
+	}
+	return stats
+}
+
+// restoreHeap 確保済み領域の一覧からヒープを作り直す, 空き領域は隙間から求める
+func restoreHeap(base, size int, used map[int]int) (*Heap, error) {
+	h := &Heap{base: base, size: size, used: make(map[int]int, len(used))}
+	starts := make([]int, 0, len(used))
+	for addr := range used {
+		starts = append(starts, addr)
+	}
+	sort.Ints(starts)
+	next, end := base, base+size
+	for _, addr := range starts {
+		n := used[addr]
+		if addr < next || n <= 0 || end < addr+n {
+			return nil, fmt.Errorf("heap: invalid block: addr=%d, size=%d", addr, n)
+		}
+		if next < addr {
+			h.free = append(h.free, block{next, addr - next})
+		}
+		h.used[addr] = n
+		next = addr + n
+	}
+	if next < end {
+		h.free = append(h.free, block{next, end - next})
+	}
+	return h, nil
+}
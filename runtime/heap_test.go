@@ -0,0 +1,86 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHeap_Alloc(t *testing.T) {
+	h := NewHeap(10)
+	a, err := h.Alloc(3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, a)
+	b, err := h.Alloc(7)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, b)
+	// 空きがない
+	_, err = h.Alloc(1)
+	assert.NotNil(t, err)
+	// 0以下は確保できない
+	_, err = h.Alloc(0)
+	assert.NotNil(t, err)
+	assert.Equal(t, HeapStats{Size: 10, Used: 10, Blocks: 2}, h.Stats())
+}
+
+func TestHeap_Free(t *testing.T) {
+	h := NewHeap(10)
+	a, _ := h.Alloc(2) // 0-1
+	b, _ := h.Alloc(3) // 2-4
+	c, _ := h.Alloc(2) // 5-6
+	_, _ = h.Alloc(3)  // 7-9
+
+	size, err := h.Free(a)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, size)
+	_, err = h.Free(c)
+	assert.Nil(t, err)
+	// 空きが2つに分かれている
+	assert.Equal(t, HeapStats{
+		Size: 10, Used: 6, Free: 4, Blocks: 2, FreeBlocks: 2, LargestFree: 2, Fragmentation: 0.5,
+	}, h.Stats())
+	// 2つ分の大きさはどちらにも入らない
+	_, err = h.Alloc(3)
+	assert.NotNil(t, err)
+
+	// 間を解放すると前後と結合される
+	_, err = h.Free(b)
+	assert.Nil(t, err)
+	assert.Equal(t, []block{{0, 7}}, h.free)
+	assert.Equal(t, 0.0, h.Stats().Fragmentation)
+	d, err := h.Alloc(7)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, d)
+
+	// 二重解放や途中のアドレスはエラー
+	_, err = h.Free(a + 1)
+	assert.NotNil(t, err)
+	_, err = h.Free(d)
+	assert.Nil(t, err)
+	_, err = h.Free(d)
+	assert.NotNil(t, err)
+}
+
+func TestHeap_Base(t *testing.T) {
+	h := NewHeapAt(3, 4) // mem[3]からmem[6]
+	a, err := h.Alloc(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, a)
+	b, err := h.Alloc(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, b)
+	_, err = h.Alloc(1)
+	assert.NotNil(t, err)
+	// 範囲の外は解放できない
+	_, err = h.Free(0)
+	assert.NotNil(t, err)
+	_, err = h.Free(a)
+	assert.Nil(t, err)
+	assert.Equal(t, HeapStats{Size: 4, Used: 2, Free: 2, Blocks: 1, FreeBlocks: 1, LargestFree: 2}, h.Stats())
+
+	// 作り直しても範囲は変わらない
+	h, err = restoreHeap(3, 4, map[int]int{5: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []block{{3, 2}}, h.free)
+	_, err = restoreHeap(3, 4, map[int]int{1: 2})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,136 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownSystemCall = errors.New("unknown system call")
+
+// HostFunc Goで書いたシステムコール
+// 戻り値は2つまでで, R10とR11に入る. 足りない分はNullになる
+type HostFunc func(args []Object) ([]Object, error)
+
+// hostFunc 登録されたシステムコール
+type hostFunc struct {
+	no    SystemCall
+	name  string
+	arity int
+	fn    HostFunc
+}
+
+// HostSystemCallBase ホスト関数に使える最小の番号, これより小さい番号は組み込みのために空けておく
+const HostSystemCallBase SystemCall = 256
+
+// RegisterHostFunc noとnameでホスト関数を登録する
+// 引数が2つまでならSyscallのオペランドから, 3つ以上ならスタックから積んだ順に取り出して渡す
+func (r *Runtime) RegisterHostFunc(no SystemCall, name string, arity int, fn HostFunc) error {
+	switch {
+	case no < HostSystemCallBase:
+		return fmt.Errorf("host syscall number must be >= %d: %d", HostSystemCallBase, no)
+	case name == "":
+		return fmt.Errorf("host syscall name is empty: %d", no)
+	case arity < 0:
+		return fmt.Errorf("invalid arity of host syscall %s: %d", name, arity)
+	case fn == nil:
+		return fmt.Errorf("host syscall %s is nil", name)
+	}
+	if _, ok := LookupSystemCall(name); ok {
+		return fmt.Errorf("host syscall name is reserved: %s", name)
+	}
+	for _, h := range r.hostFuncs {
+		if h.no == no || h.name == name {
+			return fmt.Errorf("host syscall is already registered: %d(%s)", no, name)
+		}
+	}
+	r.hostFuncs = append(r.hostFuncs, hostFunc{no, name, arity, fn})
+	return nil
+}
+
+// LookupHostFunc 名前から登録したホスト関数の番号を探す
+func (r *Runtime) LookupHostFunc(name string) (SystemCall, bool) {
+	for _, h := range r.hostFuncs {
+		if h.name == name {
+			return h.no, true
+		}
+	}
+	return 0, false
+}
+
+// hostFunc 番号かString(名前)でホスト関数を探す
+func (r *Runtime) hostFunc(no Object) (hostFunc, error) {
+	if c, ok := no.(Constant); ok {
+		v, err := r.consts.Get(c)
+		if err != nil {
+			return hostFunc{}, err
+		}
+		no = v
+	}
+	for _, h := range r.hostFuncs {
+		switch no := no.(type) {
+		case SystemCall:
+			if h.no == no {
+				return h, nil
+			}
+		case String:
+			if h.name == string(no) {
+				return h, nil
+			}
+		}
+	}
+	return hostFunc{}, fmt.Errorf("%w: %v", ErrUnknownSystemCall, no)
+}
+
+// callHost ホスト関数を呼び出し, 戻り値をR10とR11に入れる
+func (r *Runtime) callHost(h hostFunc, arg1, arg2 Object) error {
+	var args []Object
+	if h.arity <= 2 {
+		for i, arg := range []Object{arg1, arg2} {
+			if i >= h.arity {
+				if _, ok := arg.(Null); !ok {
+					return fmt.Errorf("syscall %s takes %d args, but got: %v", h.name, h.arity, arg)
+				}
+				continue
+			}
+			v, err := r.operandValue(arg)
+			if err != nil {
+				return fmt.Errorf("unsupported syscall %s arg: %w", h.name, err)
+			}
+			args = append(args, v)
+		}
+	} else {
+		if _, ok := arg1.(Null); !ok {
+			return fmt.Errorf("syscall %s takes args from stack, but got: %v", h.name, arg1)
+		}
+		if _, ok := arg2.(Null); !ok {
+			return fmt.Errorf("syscall %s takes args from stack, but got: %v", h.name, arg2)
+		}
+		if n := len(r.stack) - 1 - r.sp(); n < h.arity {
+			return fmt.Errorf("syscall %s takes %d args, but stack has %d", h.name, h.arity, n)
+		}
+		args = make([]Object, h.arity)
+		for i := h.arity - 1; i >= 0; i-- { // 最後に積んだものが最後の引数
+			v, err := r.pop()
+			if err != nil {
+				return err
+			}
+			args[i] = v
+		}
+	}
+
+	ret, err := h.fn(args)
+	if err != nil {
+		return fmt.Errorf("syscall %s: %w", h.name, err)
+	}
+	if len(ret) > 2 {
+		return fmt.Errorf("syscall %s returns %d values, but at most 2 are allowed", h.name, len(ret))
+	}
+	r.reg[R10], r.reg[R11] = Null{}, Null{}
+	for i, v := range ret {
+		if v == nil {
+			v = Null{}
+		}
+		r.reg[R10+Register(i)] = v
+	}
+	return nil
+}
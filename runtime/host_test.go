@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuntime_RegisterHostFunc(t *testing.T) {
+	fn := func(args []Object) ([]Object, error) { return nil, nil }
+	rt := NewRuntime(10, 10)
+	assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase, "config.get", 1, fn))
+	no, ok := rt.LookupHostFunc("config.get")
+	assert.True(t, ok)
+	assert.Equal(t, HostSystemCallBase, no)
+	_, ok = rt.LookupHostFunc("metrics.log")
+	assert.False(t, ok)
+
+	tests := []struct {
+		name  string
+		no    SystemCall
+		fname string
+		arity int
+		fn    HostFunc
+	}{
+		{"builtin number", Write, "print", 1, fn},
+		{"builtin name", HostSystemCallBase + 1, "write", 1, fn},
+		{"duplicated number", HostSystemCallBase, "metrics.log", 1, fn},
+		{"duplicated name", HostSystemCallBase + 1, "config.get", 1, fn},
+		{"empty name", HostSystemCallBase + 1, "", 1, fn},
+		{"negative arity", HostSystemCallBase + 1, "metrics.log", -1, fn},
+		{"nil func", HostSystemCallBase + 1, "metrics.log", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotNil(t, rt.RegisterHostFunc(tt.no, tt.fname, tt.arity, tt.fn))
+		})
+	}
+}
+
+func TestRuntime_Run_Syscall_Host(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	config := map[String]Object{"port": Integer(8080)}
+	assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase, "config.get", 1, func(args []Object) ([]Object, error) {
+		v, ok := config[args[0].(String)]
+		return []Object{v, Bool(ok)}, nil
+	}))
+	var logged []Object
+	assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase+1, "metrics.log", 3, func(args []Object) ([]Object, error) {
+		logged = args
+		return []Object{Integer(len(args))}, nil
+	}))
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, String("port"),
+		Syscall, HostSystemCallBase, R1, Null{},
+		Mov, R2, R10,
+		Mov, R3, R11,
+		Syscall, String("config.get"), String("host"), Null{}, // 名前でも呼べる
+		Mov, R4, R10,
+		Mov, R5, R11,
+		Push, String("latency"),
+		Push, Integer(12),
+		Push, Character('m'),
+		Syscall, String("metrics.log"), Null{}, Null{}, // 3つ以上はスタックから
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(8080), rt.reg[R2])
+	assert.Equal(t, True, rt.reg[R3])
+	assert.Equal(t, Null{}, rt.reg[R4])
+	assert.Equal(t, False, rt.reg[R5])
+	assert.Equal(t, []Object{String("latency"), Integer(12), Character('m')}, logged)
+	assert.Equal(t, Integer(3), rt.reg[R10])
+	assert.Equal(t, Null{}, rt.reg[R11])
+}
+
+func TestRuntime_Run_Syscall_Host_Error(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name   string
+		prog   Program
+		expect error
+	}{
+		{"unknown number", Program{DefLabel(0), Syscall, HostSystemCallBase + 9, Null{}, Null{}, Ret}, ErrUnknownSystemCall},
+		{"unknown name", Program{DefLabel(0), Syscall, String("nope"), Null{}, Null{}, Ret}, ErrUnknownSystemCall},
+		{"too many args", Program{DefLabel(0), Syscall, String("one"), Integer(1), Integer(2), Ret}, nil},
+		{"stack underflow", Program{DefLabel(0), Syscall, String("three"), Null{}, Null{}, Ret}, nil},
+		{"operand for stack args", Program{DefLabel(0), Push, Integer(1), Push, Integer(2), Push, Integer(3), Syscall, String("three"), Integer(1), Null{}, Ret}, nil},
+		{"host error", Program{DefLabel(0), Syscall, String("fail"), Null{}, Null{}, Ret}, errFailed},
+		{"too many results", Program{DefLabel(0), Syscall, String("many"), Null{}, Null{}, Ret}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 10)
+			assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase, "one", 1, func(args []Object) ([]Object, error) { return nil, nil }))
+			assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase+1, "three", 3, func(args []Object) ([]Object, error) { return nil, nil }))
+			assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase+2, "fail", 0, func(args []Object) ([]Object, error) { return nil, errFailed }))
+			assert.Nil(t, rt.RegisterHostFunc(HostSystemCallBase+3, "many", 0, func(args []Object) ([]Object, error) {
+				return []Object{Null{}, Null{}, Null{}}, nil
+			}))
+			rt.Load(tt.prog)
+			assert.Nil(t, rt.CollectLabels())
+			err := rt.Run()
+			assert.NotNil(t, err)
+			if tt.expect != nil {
+				assert.ErrorIs(t, err, tt.expect)
+			}
+		})
+	}
+}
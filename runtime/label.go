@@ -14,6 +14,17 @@ func (l Label) String() string {
 	return strconv.Itoa(l.Value())
 }
 
+// LabelName namesにあればその名前, なければmainかl_3のような名前にする
+func LabelName(l Label, names map[Label]string) string {
+	if name, ok := names[l]; ok {
+		return name
+	}
+	if l == 0 {
+		return "main"
+	}
+	return "l_" + l.String()
+}
+
 type DefLabel int
 
 func (d DefLabel) Value() int {
@@ -11,7 +11,34 @@ func (m MemoryOffset) Value() int {
 	return int(m)
 }
 func (m MemoryOffset) String() string {
-	return strconv.Itoa(m.Value())
+	return "mem[" + strconv.Itoa(m.Value()) + "]"
+}
+
+// MemoryRelativeOffset レジスタの値を基準にしたメモリの位置, mem[r1+2]
+type MemoryRelativeOffset struct {
+	target           Register
+	relativeDistance int
+}
+
+func NewMemoryRelativeOffset(target Register, relativeDistance int) *MemoryRelativeOffset {
+	return &MemoryRelativeOffset{target, relativeDistance}
+}
+
+func (m MemoryRelativeOffset) Value() int {
+	return m.relativeDistance
+}
+func (m MemoryRelativeOffset) String() string {
+	str := "mem["
+	str += m.target.String()
+	if 0 <= m.Value() {
+		str += "+"
+	}
+	str += strconv.Itoa(m.Value())
+	str += "]"
+	return str
+}
+func (m MemoryRelativeOffset) Target() Register {
+	return m.target
 }
 
 type Memory []Object
@@ -29,8 +56,11 @@ func (m *Memory) Set(offset MemoryOffset, obj Object) error {
 	return fmt.Errorf("offset must be 0< = x < %d", len(*m))
 }
 
-func (m *Memory) Get(offset MemoryOffset) Object {
-	return (*m)[offset.Value()]
+func (m *Memory) Get(offset MemoryOffset) (Object, error) {
+	if 0 <= offset.Value() && offset.Value() < len(*m) {
+		return (*m)[offset.Value()], nil
+	}
+	return nil, fmt.Errorf("offset must be 0< = x < %d", len(*m))
 }
 
 func (m *Memory) Delete(offset MemoryOffset) {
@@ -3,6 +3,8 @@ package runtime
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type Object interface {
@@ -65,3 +67,47 @@ func (l List) Value() int {
 func (l List) String() string {
 	return fmt.Sprintf("list(%d)", l.Value())
 }
+
+type Float float64
+
+// Value 小数点以下は切り捨て
+func (f Float) Value() int {
+	return int(f)
+}
+
+// String 整数になる値でも1.0のように小数だとわかる形にする
+func (f Float) String() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+type String string
+
+// Value 文字数
+func (s String) Value() int {
+	return utf8.RuneCountInString(string(s))
+}
+func (s String) String() string {
+	return string(s)
+}
+
+// At idx文字目, 範囲外はエラー
+func (s String) At(idx int) (Character, error) {
+	runes := []rune(string(s))
+	if idx < 0 || len(runes) <= idx {
+		return 0, fmt.Errorf("string index out of range: %d, len=%d", idx, len(runes))
+	}
+	return Character(runes[idx]), nil
+}
+
+// Slice start文字目からend文字目の手前まで
+func (s String) Slice(start, end int) (String, error) {
+	runes := []rune(string(s))
+	if start < 0 || end < start || len(runes) < end {
+		return "", fmt.Errorf("string slice out of range: [%d:%d], len=%d", start, end, len(runes))
+	}
+	return String(runes[start:end]), nil
+}
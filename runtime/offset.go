@@ -19,6 +19,10 @@ type StackRelativeOffset struct {
 	relativeDistance int
 }
 
+func NewStackRelativeOffset(target Register, relativeDistance int) *StackRelativeOffset {
+	return &StackRelativeOffset{target, relativeDistance}
+}
+
 func NewBPOffset(relativeDistance int) *StackRelativeOffset {
 	return &StackRelativeOffset{BasePointer, relativeDistance}
 }
@@ -34,5 +38,8 @@ func (s StackRelativeOffset) String() string {
 	}
 	str += strconv.Itoa(s.Value())
 	str += "]"
-	return fmt.Sprintf("%s(%d)", s.target.String(), s.Value())
+	return str
+}
+func (s StackRelativeOffset) Target() Register {
+	return s.target
 }
@@ -1,38 +1,87 @@
 package runtime
 
+import "strings"
+
 type Opcode int
 
 func (o Opcode) Value() int {
 	return int(o)
 }
 
-func (o Opcode) String() string {
-	var kinds = [...]string{
-		Nop: "Nop",
-
-		Exit: "Exit",
-
-		Mov:  "Mov",
-		Push: "Push",
-		Pop:  "Pop",
-
-		Call: "Call",
-		Ret:  "Ret",
-
-		Add: "Add",
-		Sub: "Sub",
+var opcodeKinds = [...]string{
+	Nop: "Nop",
+
+	Exit: "Exit",
+
+	Mov:  "Mov",
+	Push: "Push",
+	Pop:  "Pop",
+
+	Call: "Call",
+	Ret:  "Ret",
+
+	Add: "Add",
+	Sub: "Sub",
+	Mul: "Mul",
+	Div: "Div",
+	Mod: "Mod",
+
+	And: "And",
+	Or:  "Or",
+	Xor: "Xor",
+	Not: "Not",
+	Shl: "Shl",
+	Shr: "Shr",
+
+	Gt:  "Gt",
+	Ge:  "Ge",
+	Neg: "Neg",
+
+	Beq: "Beq",
+	Bne: "Bne",
+	Blt: "Blt",
+	Ble: "Ble",
+	Bgt: "Bgt",
+	Bge: "Bge",
+
+	Load:  "Load",
+	Store: "Store",
+
+	Itof: "Itof",
+	Ftoi: "Ftoi",
+
+	Concat: "Concat",
+	Len:    "Len",
+	Index:  "Index",
+	Substr: "Substr",
+
+	Jmp: "Jmp",
+	Je:  "Je",
+	Jne: "Jne",
+
+	Eq:      "Eq",
+	Ne:      "Ne",
+	Lt:      "Lt",
+	Le:      "Le",
+	Syscall: "Syscall",
+
+	Spawn: "Spawn",
+	Yield: "Yield",
+	Join:  "Join",
+}
 
-		Jmp: "Jmp",
-		Je:  "Je",
-		Jne: "Jne",
+func (o Opcode) String() string {
+	return opcodeKinds[o]
+}
 
-		Eq:      "Eq",
-		Ne:      "Ne",
-		Lt:      "Lt",
-		Le:      "Le",
-		Syscall: "Syscall",
+// LookupOpcode 大文字小文字を区別せずに名前から命令を探す
+func LookupOpcode(name string) (Opcode, bool) {
+	for op, kind := range opcodeKinds {
+		if strings.EqualFold(kind, name) {
+			return Opcode(op), true
+		}
 	}
-	return kinds[o]
+	return 0, false
 }
 
 const (
@@ -60,17 +109,59 @@ const (
 	Le
 
 	Syscall
+
+	// バイトコードの番号を変えないように後ろに追加していく
+	Mul
+	Div
+	Mod
+
+	And
+	Or
+	Xor
+	Not
+	Shl
+	Shr
+
+	Gt
+	Ge
+	Neg
+
+	// 比較して分岐, zfは変更しない
+	Beq
+	Bne
+	Blt
+	Ble
+	Bgt
+	Bge
+
+	Load
+	Store
+
+	// 整数と小数の変換
+	Itof
+	Ftoi
+
+	// 文字列, 位置と長さは文字単位
+	Concat
+	Len
+	Index
+	Substr
+
+	// グリーンスレッド, 切り替えはRuntimeのスケジューラが行う
+	Spawn
+	Yield
+	Join
 )
 
 func Operand(op Opcode) int {
 	switch op {
-	case Nop, Exit, Ret:
+	case Nop, Exit, Ret, Yield:
 		return 0
-	case Push, Pop, Call, Jmp, Je, Jne:
+	case Push, Pop, Call, Jmp, Je, Jne, Not, Neg, Itof, Ftoi, Spawn, Join:
 		return 1
-	case Mov, Add, Sub, Eq, Ne, Lt, Le:
+	case Mov, Add, Sub, Mul, Div, Mod, And, Or, Xor, Shl, Shr, Eq, Ne, Lt, Le, Gt, Ge, Load, Store, Concat, Len:
 		return 2
-	case Syscall:
+	case Syscall, Beq, Bne, Blt, Ble, Bgt, Bge, Index, Substr:
 		return 3
 	default:
 		return 0
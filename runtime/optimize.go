@@ -0,0 +1,225 @@
+package runtime
+
+// Optimize 覗き穴最適化をしたプログラムを返す, progは変更しない
+//
+//   - push x; pop reg を mov reg x にする
+//   - add/sub sp 0 のような大きさ0のスタックの調整を消す
+//   - 直後のラベルへのjmpを消す
+//   - jmpだけのラベルへの飛び先をその先に付け替える
+//
+// ラベルの定義は消さないので, CollectLabelsやブレークポイントはそのまま使える.
+// 命令の位置は変わるので, DebugInfoの位置は使えなくなる.
+// オペランドの足りない命令などがあれば, 何もせずに写しを返す.
+func Optimize(prog Program) Program {
+	code, ok := splitChunks(prog)
+	if !ok {
+		return append(Program{}, prog...)
+	}
+	passes := []func([]chunk) ([]chunk, bool){
+		collapsePushPop,
+		dropZeroStackAdjust,
+		threadJumps,
+		dropJumpToNext,
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range passes {
+			var c bool
+			code, c = pass(code)
+			changed = changed || c
+		}
+	}
+	optimized := Program{}
+	for _, c := range code {
+		optimized = append(optimized, c...)
+	}
+	return optimized
+}
+
+// chunk ラベルの定義1つか, 命令とそのオペランド
+type chunk []Object
+
+func (c chunk) op() (Opcode, bool) {
+	op, ok := c[0].(Opcode)
+	return op, ok
+}
+
+func (c chunk) is(op Opcode) bool {
+	o, ok := c.op()
+	return ok && o == op
+}
+
+func splitChunks(prog Program) ([]chunk, bool) {
+	var code []chunk
+	for pc := 0; pc < len(prog); {
+		switch obj := prog[pc].(type) {
+		case DefLabel:
+			code = append(code, chunk{obj})
+			pc++
+		case Opcode:
+			n := Operand(obj)
+			if len(prog) <= pc+n {
+				return nil, false
+			}
+			code = append(code, chunk(prog[pc:pc+1+n]))
+			pc += 1 + n
+		default:
+			return nil, false
+		}
+	}
+	return code, true
+}
+
+// collapsePushPop push x; pop reg -> mov reg x
+// 間にラベルがあるとそこへ飛んでくることがあるので, 並んでいるものだけ
+func collapsePushPop(code []chunk) ([]chunk, bool) {
+	var out []chunk
+	changed := false
+	for i := 0; i < len(code); i++ {
+		if i+1 < len(code) && code[i].is(Push) && code[i+1].is(Pop) && isMovSource(code[i][1]) {
+			src, dest := code[i][1], code[i+1][1]
+			if _, ok := dest.(Register); ok {
+				if src != dest { // push r1; pop r1 は何もしない
+					out = append(out, chunk{Mov, dest, src})
+				}
+				i++
+				changed = true
+				continue
+			}
+		}
+		out = append(out, code[i])
+	}
+	return out, changed
+}
+
+// isMovSource movのsrcにできるもの
+func isMovSource(obj Object) bool {
+	switch obj.(type) {
+	case Register, StackRelativeOffset, Integer, Float, Character, Bool, Null, String, Constant:
+		return true
+	default:
+		return false
+	}
+}
+
+// dropZeroStackAdjust add/sub sp 0 と, mov reg 0 の直後の add/sub sp reg を消す
+func dropZeroStackAdjust(code []chunk) ([]chunk, bool) {
+	var out []chunk
+	changed := false
+	for i, c := range code {
+		if (c.is(Add) || c.is(Sub)) && c[1] == StackPointer {
+			size := c[2]
+			if reg, ok := size.(Register); ok && 0 < i && code[i-1].is(Mov) && code[i-1][1] == reg {
+				size = code[i-1][2]
+			}
+			if size == Integer(0) {
+				changed = true
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out, changed
+}
+
+// jumpLabel 飛び先がラベルの命令なら, ラベルのあるオペランドの位置
+func jumpLabel(c chunk) (int, bool) {
+	op, ok := c.op()
+	if !ok {
+		return 0, false
+	}
+	switch op {
+	case Jmp, Je, Jne:
+		_, ok := c[1].(Label)
+		return 1, ok
+	case Beq, Bne, Blt, Ble, Bgt, Bge:
+		_, ok := c[3].(Label)
+		return 3, ok
+	default:
+		return 0, false
+	}
+}
+
+// threadJumps jmpしかないラベルへ飛ぶなら, その先へ直接飛ぶ
+func threadJumps(code []chunk) ([]chunk, bool) {
+	// ラベル: そのラベルの最初の命令がjmpならその飛び先
+	forward := map[Label]Label{}
+	for i, c := range code {
+		def, ok := c[0].(DefLabel)
+		if !ok {
+			continue
+		}
+		j := i + 1
+		for j < len(code) && !isInstruction(code[j]) {
+			j++
+		}
+		if j < len(code) && code[j].is(Jmp) {
+			if dest, ok := code[j][1].(Label); ok {
+				forward[Label(def.Value())] = dest
+			}
+		}
+	}
+	resolve := func(l Label) Label {
+		seen := map[Label]bool{l: true}
+		for {
+			next, ok := forward[l]
+			if !ok || seen[next] { // 無限ループは付け替えない
+				return l
+			}
+			seen[next] = true
+			l = next
+		}
+	}
+
+	changed := false
+	out := make([]chunk, len(code))
+	for i, c := range code {
+		out[i] = c
+		at, ok := jumpLabel(c)
+		if !ok {
+			continue
+		}
+		if dest := resolve(c[at].(Label)); dest != c[at] {
+			threaded := append(chunk{}, c...)
+			threaded[at] = dest
+			out[i] = threaded
+			changed = true
+		}
+	}
+	return out, changed
+}
+
+// dropJumpToNext すぐ後ろのラベルへのjmp, je, jneを消す
+func dropJumpToNext(code []chunk) ([]chunk, bool) {
+	var out []chunk
+	changed := false
+	for i, c := range code {
+		if c.is(Jmp) || c.is(Je) || c.is(Jne) {
+			if dest, ok := c[1].(Label); ok && labelFollows(code[i+1:], dest) {
+				changed = true
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out, changed
+}
+
+// labelFollows 次の命令までの間にラベルの定義があるか
+func labelFollows(code []chunk, l Label) bool {
+	for _, c := range code {
+		def, ok := c[0].(DefLabel)
+		if !ok {
+			return false
+		}
+		if Label(def.Value()) == l {
+			return true
+		}
+	}
+	return false
+}
+
+func isInstruction(c chunk) bool {
+	_, ok := c.op()
+	return ok
+}
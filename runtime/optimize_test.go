@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		expect Program
+	}{
+		{
+			"push pop to mov",
+			Program{
+				DefLabel(0),
+				Push, Integer(1),
+				Pop, R1,
+				Push, *NewBPOffset(-1),
+				Pop, R2,
+				Push, R3,
+				Pop, R3,
+				Mov, ACM1, R1,
+			},
+			Program{
+				DefLabel(0),
+				Mov, R1, Integer(1),
+				Mov, R2, *NewBPOffset(-1),
+				Mov, ACM1, R1,
+			},
+		},
+		{
+			"push pop across label",
+			Program{Push, Integer(1), DefLabel(1), Pop, R1},
+			Program{Push, Integer(1), DefLabel(1), Pop, R1},
+		},
+		{
+			"zero stack adjustment",
+			Program{
+				Push, Integer(0),
+				Pop, R1,
+				Sub, StackPointer, R1,
+				Add, StackPointer, Integer(0),
+				Sub, StackPointer, Integer(2),
+			},
+			Program{
+				Mov, R1, Integer(0),
+				Sub, StackPointer, Integer(2),
+			},
+		},
+		{
+			"jump to next",
+			Program{
+				Je, Label(1),
+				Jmp, Label(2),
+				DefLabel(1),
+				Jmp, Label(2),
+				DefLabel(2),
+				Ret,
+			},
+			Program{
+				DefLabel(1),
+				DefLabel(2),
+				Ret,
+			},
+		},
+		{
+			"thread jumps",
+			Program{
+				Beq, R1, R2, Label(1),
+				Jne, Label(2),
+				Ret,
+				DefLabel(1),
+				Jmp, Label(2),
+				DefLabel(2),
+				DefLabel(3),
+				Jmp, Label(4),
+				DefLabel(4),
+				Jmp, Label(4), // 自分へのjmpは付け替えない
+			},
+			Program{
+				Beq, R1, R2, Label(4),
+				Jne, Label(4),
+				Ret,
+				DefLabel(1),
+				DefLabel(2),
+				DefLabel(3),
+				DefLabel(4),
+				Jmp, Label(4),
+			},
+		},
+		{
+			"malformed program is left as is",
+			Program{Push, Integer(1), Pop},
+			Program{Push, Integer(1), Pop},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := append(Program{}, tt.prog...)
+			assert.Equal(t, tt.expect, Optimize(tt.prog))
+			assert.Equal(t, orig, tt.prog)
+		})
+	}
+}
+
+// 最適化しても同じ結果になり, 実行する命令は減る
+func TestOptimize_Behavior(t *testing.T) {
+	tests := []struct {
+		name string
+		prog Program
+	}{
+		{"fizzbuzz", fizzBuzzProgram()},
+		{"fibonacci", fibonacciProgram(10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			optimized := Optimize(tt.prog)
+			assert.Nil(t, Verify(optimized))
+			assert.Less(t, len(optimized), len(tt.prog))
+
+			var origOut, optOut bytes.Buffer
+			orig := NewRuntime(1000, 10, WithStdout(&origOut))
+			opt := NewRuntime(1000, 10, WithStdout(&optOut))
+			orig.Load(tt.prog)
+			opt.Load(optimized)
+			for _, rt := range []*Runtime{orig, opt} {
+				assert.Nil(t, rt.CollectLabels())
+				assert.Nil(t, rt.Run())
+			}
+			assert.Equal(t, origOut.String(), optOut.String())
+			assert.Equal(t, orig.Status(), opt.Status())
+			assert.Equal(t, orig.reg[R10], opt.reg[R10])
+			assert.Equal(t, orig.reg[StackPointer], opt.reg[StackPointer])
+			assert.Less(t, opt.Executed(), orig.Executed())
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"bufio"
+	"io"
+)
+
+// Option NewRuntimeで設定を変える
+type Option func(r *Runtime)
+
+// WithStdin StdInから読む先を変える, 指定しなければos.Stdin
+func WithStdin(in io.Reader) Option {
+	return func(r *Runtime) {
+		r.stdin = bufio.NewReader(in)
+	}
+}
+
+// WithStdout StdOutへの書き込み先を変える, 指定しなければos.Stdout
+func WithStdout(out io.Writer) Option {
+	return func(r *Runtime) {
+		r.stdout = out
+	}
+}
+
+// WithStderr StdErrへの書き込み先を変える, 指定しなければos.Stderr
+func WithStderr(out io.Writer) Option {
+	return func(r *Runtime) {
+		r.stderr = out
+	}
+}
+
+// WithVerify Loadで実行する前にVerifyでプログラムを確かめる
+func WithVerify() Option {
+	return func(r *Runtime) {
+		r.verify = true
+	}
+}
+
+// WithHeapBase mem[0]からmem[n-1]を大域変数に使い, ヒープはmem[n]から使う
+// 指定しなければメモリ全体がヒープになるので, 大域変数を置くプログラムは必ず指定する
+func WithHeapBase(n int) Option {
+	return func(r *Runtime) {
+		r.heapBase = n
+	}
+}
@@ -0,0 +1,218 @@
+package runtime
+
+import (
+	"fmt"
+	"github.com/google/pprof/profile"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profiler 実行した命令の数を関数ごとに数える
+// 関数はCallで呼んだラベルで, Call/Retを追って呼び出しの連なりも記録する
+type Profiler struct {
+	stacks  map[int][]Label // スレッドごとの呼び出しの連なり, 先頭が一番外側
+	samples map[string]*ProfileSample
+	start   time.Time
+}
+
+// ProfileSample 同じ呼び出しの連なりで実行した命令の数
+type ProfileSample struct {
+	Stack []Label // 先頭が実行中の関数
+	Count int
+}
+
+// FunctionProfile 関数ごとの命令の数
+type FunctionProfile struct {
+	Label Label
+	Flat  int // その関数自身で実行した数
+	Cum   int // 呼び出した関数の分も含めた数
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		stacks:  map[int][]Label{},
+		samples: map[string]*ProfileSample{},
+		start:   time.Now(),
+	}
+}
+
+// SetProfiler 命令ごとに数えるプロファイラを設定する, nilで止める
+func (r *Runtime) SetProfiler(p *Profiler) {
+	r.prof = p
+}
+
+// observe pcの命令を数え, Call/Retなら呼び出しの連なりを更新する
+// 呼び出しの連なりは実行中のスレッドのものを使う
+func (p *Profiler) observe(r *Runtime) {
+	id := r.Thread()
+	stack := p.stacks[id]
+	if len(stack) == 0 { // 途中から数え始めたか, 一番外側から戻った
+		label := r.enclosingLabel(r.pc())
+		if id != 0 && label != Label(-1) { // Spawnしたスレッドはそのラベルから始まる
+			label = r.threadLabel()
+		}
+		stack = append(stack, label)
+	}
+	var key strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		key.WriteString(strconv.Itoa(stack[i].Value()))
+		key.WriteByte(',')
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &ProfileSample{Stack: make([]Label, len(stack))}
+		for i, l := range stack {
+			s.Stack[len(stack)-1-i] = l
+		}
+		p.samples[key.String()] = s
+	}
+	s.Count++
+
+	switch r.program[r.pc()] {
+	case Call:
+		if label, ok := r.program[r.pc()+1].(Label); ok {
+			stack = append(stack, label)
+		}
+	case Ret:
+		stack = stack[:len(stack)-1]
+	}
+	p.stacks[id] = stack
+}
+
+// enclosingLabel pcより前にある一番近いラベル
+func (r *Runtime) enclosingLabel(pc int) Label {
+	for i := min(pc, len(r.program)-1); i >= 0; i-- {
+		if label, ok := r.program[i].(DefLabel); ok {
+			return Label(label.Value())
+		}
+	}
+	return Label(-1)
+}
+
+// Samples 呼び出しの連なりごとの命令の数, 多い順
+func (p *Profiler) Samples() []ProfileSample {
+	samples := make([]ProfileSample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, ProfileSample{append([]Label{}, s.Stack...), s.Count})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Count != samples[j].Count {
+			return samples[i].Count > samples[j].Count
+		}
+		return fmt.Sprint(samples[i].Stack) < fmt.Sprint(samples[j].Stack)
+	})
+	return samples
+}
+
+// Functions 関数ごとの命令の数, Flatの多い順
+func (p *Profiler) Functions() []FunctionProfile {
+	stats := map[Label]*FunctionProfile{}
+	get := func(l Label) *FunctionProfile {
+		if _, ok := stats[l]; !ok {
+			stats[l] = &FunctionProfile{Label: l}
+		}
+		return stats[l]
+	}
+	for _, s := range p.samples {
+		get(s.Stack[0]).Flat += s.Count
+		seen := map[Label]bool{} // 再帰していても1回だけ数える
+		for _, l := range s.Stack {
+			if !seen[l] {
+				seen[l] = true
+				get(l).Cum += s.Count
+			}
+		}
+	}
+	funcs := make([]FunctionProfile, 0, len(stats))
+	for _, f := range stats {
+		funcs = append(funcs, *f)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].Flat != funcs[j].Flat {
+			return funcs[i].Flat > funcs[j].Flat
+		}
+		if funcs[i].Cum != funcs[j].Cum {
+			return funcs[i].Cum > funcs[j].Cum
+		}
+		return funcs[i].Label < funcs[j].Label
+	})
+	return funcs
+}
+
+// Total 数えた命令の数
+func (p *Profiler) Total() int {
+	total := 0
+	for _, s := range p.samples {
+		total += s.Count
+	}
+	return total
+}
+
+// WriteTop Flatの多いn個の関数を表にする, nが0以下なら全部
+func (p *Profiler) WriteTop(w io.Writer, n int, names map[Label]string) error {
+	total := p.Total()
+	percent := func(v int) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(v) * 100 / float64(total)
+	}
+	funcs := p.Functions()
+	if 0 < n && n < len(funcs) {
+		funcs = funcs[:n]
+	}
+	if _, err := fmt.Fprintf(w, "total %d instructions\n%10s %7s %10s %7s  %s\n", total, "flat", "flat%", "cum", "cum%", "function"); err != nil {
+		return err
+	}
+	for _, f := range funcs {
+		if _, err := fmt.Fprintf(w, "%10d %6.2f%% %10d %6.2f%%  %s\n", f.Flat, percent(f.Flat), f.Cum, percent(f.Cum), LabelName(f.Label, names)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePprof go tool pprofで読めるgzip圧縮したprotobufを書き出す
+func (p *Profiler) WritePprof(w io.Writer, names map[Label]string) error {
+	return p.pprof(names).Write(w)
+}
+
+// pprof 呼び出しの連なりごとの命令の数をProfileにする
+func (p *Profiler) pprof(names map[Label]string) *profile.Profile {
+	instructions := &profile.ValueType{Type: "instructions", Unit: "count"}
+	prof := &profile.Profile{
+		SampleType:    []*profile.ValueType{instructions},
+		PeriodType:    instructions,
+		Period:        1,
+		TimeNanos:     p.start.UnixNano(),
+		DurationNanos: time.Since(p.start).Nanoseconds(),
+	}
+	// 関数名を持っているのでシンボル解決は要らない
+	mapping := &profile.Mapping{ID: 1, File: "barba", HasFunctions: true}
+	prof.Mapping = []*profile.Mapping{mapping}
+
+	// 関数ごとにfunctionとlocationを1つずつ作る, idは同じ番号
+	locs := map[Label]*profile.Location{}
+	for _, s := range p.Samples() {
+		sample := &profile.Sample{Value: []int64{int64(s.Count)}}
+		for _, l := range s.Stack {
+			loc, ok := locs[l]
+			if !ok {
+				id := uint64(len(prof.Location) + 1)
+				name := LabelName(l, names)
+				fn := &profile.Function{ID: id, Name: name, SystemName: name}
+				loc = &profile.Location{ID: id, Mapping: mapping, Address: id, Line: []profile.Line{{Function: fn}}}
+				prof.Function = append(prof.Function, fn)
+				prof.Location = append(prof.Location, loc)
+				locs[l] = loc
+			}
+			sample.Location = append(sample.Location, loc)
+		}
+		prof.Sample = append(prof.Sample, sample)
+	}
+	mapping.Limit = uint64(len(prof.Location) + 1)
+	return prof
+}
@@ -0,0 +1,158 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// countdownProgram l_1(n)はnが0になるまで自分を呼び, mainはl_1(2)とl_2を呼ぶ
+func countdownProgram() Program {
+	return Program{
+		DefLabel(1),
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Mov, R1, *NewBPOffset(2),
+		Beq, R1, Integer(0), Label(3),
+		Sub, R1, Integer(1),
+		Push, R1,
+		Call, Label(1),
+		Pop, R1,
+		DefLabel(3), // 関数の中のラベルは関数として扱わない
+		Mov, StackPointer, BasePointer,
+		Pop, BasePointer,
+		Ret,
+		DefLabel(2),
+		Mov, R2, Integer(0),
+		Ret,
+		DefLabel(0),
+		Push, Integer(2),
+		Call, Label(1),
+		Pop, R1,
+		Call, Label(2),
+		Ret,
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	rt := NewRuntime(20, 1)
+	rt.Load(countdownProgram())
+	assert.Nil(t, rt.CollectLabels())
+	p := NewProfiler()
+	rt.SetProfiler(p)
+	assert.Nil(t, rt.Run())
+
+	// l_1は再帰の深さごとに分かれる
+	// n=2,1: push mov mov beq sub push call pop mov pop ret = 11
+	// n=0: push mov mov beq mov pop ret = 7
+	assert.Equal(t, []ProfileSample{
+		{[]Label{1, 0, -1}, 11},
+		{[]Label{1, 1, 0, -1}, 11},
+		{[]Label{1, 1, 1, 0, -1}, 7},
+		{[]Label{0, -1}, 5},
+		{[]Label{-1}, 2},
+		{[]Label{2, 0, -1}, 2},
+	}, p.Samples())
+	assert.Equal(t, 38, p.Total())
+	assert.Equal(t, []FunctionProfile{
+		{Label: 1, Flat: 29, Cum: 29},
+		{Label: 0, Flat: 5, Cum: 36},
+		{Label: -1, Flat: 2, Cum: 38},
+		{Label: 2, Flat: 2, Cum: 2},
+	}, p.Functions())
+
+	var buf bytes.Buffer
+	assert.Nil(t, p.WriteTop(&buf, 2, map[Label]string{1: "countdown"}))
+	assert.Equal(t, `total 38 instructions
+      flat   flat%        cum    cum%  function
+        29  76.32%         29  76.32%  countdown
+         5  13.16%         36  94.74%  main
+`, buf.String())
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	rt := NewRuntime(20, 1)
+	rt.Load(countdownProgram())
+	assert.Nil(t, rt.CollectLabels())
+	p := NewProfiler()
+	rt.SetProfiler(p)
+	assert.Nil(t, rt.Run())
+
+	var buf bytes.Buffer
+	names := map[Label]string{1: "countdown"}
+	assert.Nil(t, p.WritePprof(&buf, names))
+	prof, err := profile.Parse(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, prof.CheckValid())
+	assert.Equal(t, []*profile.ValueType{{Type: "instructions", Unit: "count"}}, prof.SampleType)
+
+	// 呼び出しの連なりと数がSamplesと揃っている, どちらも呼ばれた側が先
+	type sample struct {
+		stack []string
+		count int64
+	}
+	var expect, actual []sample
+	for _, s := range p.Samples() {
+		var stack []string
+		for _, l := range s.Stack {
+			stack = append(stack, LabelName(l, names))
+		}
+		expect = append(expect, sample{stack, int64(s.Count)})
+	}
+	for _, s := range prof.Sample {
+		var stack []string
+		for _, loc := range s.Location {
+			if assert.Len(t, loc.Line, 1) {
+				stack = append(stack, loc.Line[0].Function.Name)
+			}
+		}
+		actual = append(actual, sample{stack, s.Value[0]})
+	}
+	assert.ElementsMatch(t, expect, actual)
+
+	// 関数ごとにlocationが1つ
+	var funcs []string
+	for _, loc := range prof.Location {
+		funcs = append(funcs, loc.Line[0].Function.Name)
+	}
+	assert.ElementsMatch(t, []string{"main", "countdown", "l_2", "l_-1"}, funcs)
+}
+
+// スレッドごとに呼び出しの連なりを追う
+func TestProfiler_Thread(t *testing.T) {
+	rt := NewRuntime(20, 1, WithTimeSlice(2))
+	rt.Load(Program{
+		DefLabel(1),
+		Call, Label(3),
+		Ret,
+		DefLabel(3),
+		Mov, R1, Integer(1),
+		Ret,
+		DefLabel(0),
+		Spawn, Label(1),
+		Mov, R6, R10,
+		Spawn, Label(1),
+		Mov, R7, R10,
+		Join, R6,
+		Join, R7,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	p := NewProfiler()
+	rt.SetProfiler(p)
+	assert.Nil(t, rt.Run())
+
+	// 2つのスレッドで call ret と mov ret, 終わるときのexit
+	samples := map[string]int{}
+	for _, s := range p.Samples() {
+		samples[fmt.Sprint(s.Stack)] = s.Count
+	}
+	assert.Equal(t, 4, samples["[1]"])
+	assert.Equal(t, 4, samples["[3 1]"])
+	assert.Equal(t, 0, samples["[3 0 -1]"])
+	assert.Equal(t, 4, samples["[-1]"]) // call mainとexitが3回
+}
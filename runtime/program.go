@@ -1,3 +1,17 @@
 package runtime
 
 type Program []Object
+
+// firstOpcode pcから見て最初の命令, ラベル以外に当たるか終わりまで来たら-1
+func (p Program) firstOpcode(pc int) int {
+	for ; 0 <= pc && pc < len(p); pc++ {
+		switch p[pc].(type) {
+		case Opcode:
+			return pc
+		case DefLabel:
+		default:
+			return -1
+		}
+	}
+	return -1
+}
@@ -5,34 +5,46 @@ type Register int
 func (r Register) Value() int {
 	return int(r)
 }
+
+var registerKinds = [...]string{
+	ProgramCounter: "pc",
+	BasePointer:    "bp",
+	StackPointer:   "sp",
+	ZeroFlag:       "zf",
+	ExitFlag:       "ef",
+	General1:       "g1",
+	General2:       "g2",
+	Temporal1:      "t1",
+	R0:             "r0",
+	R1:             "r1",
+	R2:             "r2",
+	R3:             "r3",
+	R4:             "r4",
+	R5:             "r5",
+	R6:             "r6",
+	R7:             "r7",
+	R8:             "r8",
+	R9:             "r9",
+	R10:            "r10",
+	R11:            "r11",
+	R12:            "r12",
+	ACM1:           "acm1",
+	ACM2:           "acm2",
+	_reg_end:       "",
+}
+
 func (r Register) String() string {
-	var kinds = [...]string{
-		ProgramCounter: "pc",
-		BasePointer:    "bp",
-		StackPointer:   "sp",
-		ZeroFlag:       "zf",
-		ExitFlag:       "ef",
-		General1:       "g1",
-		General2:       "g2",
-		Temporal1:      "t1",
-		R0:             "r0",
-		R1:             "r1",
-		R2:             "r2",
-		R3:             "r3",
-		R4:             "r4",
-		R5:             "r5",
-		R6:             "r6",
-		R7:             "r7",
-		R8:             "r8",
-		R9:             "r9",
-		R10:            "r10",
-		R11:            "r11",
-		R12:            "r12",
-		ACM1:           "acm1",
-		ACM2:           "acm2",
-		_reg_end:       "",
+	return registerKinds[r]
+}
+
+// LookupRegister 名前からレジスタを探す
+func LookupRegister(name string) (Register, bool) {
+	for reg := ProgramCounter; reg < _reg_end; reg++ {
+		if registerKinds[reg] == name {
+			return reg, true
+		}
 	}
-	return kinds[r]
+	return 0, false
 }
 
 const (
@@ -66,6 +78,6 @@ const (
 )
 
 func NewRegisterSet() *[]Object {
-	rSet := make([]Object, _reg_end-1)
+	rSet := make([]Object, _reg_end)
 	return &rSet
 }
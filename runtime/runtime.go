@@ -1,20 +1,45 @@
 package runtime
 
 import (
+	"bufio"
+	"cmp"
+	"context"
 	"fmt"
-	"os"
+	"io"
+	"math"
+	"math/rand/v2"
 	"reflect"
 )
 
 type Runtime struct {
-	program Program
-	sym     SymbolTable
-	reg     []Object
-	stack   []Object
-	mem     Memory
+	program     Program
+	sym         SymbolTable
+	reg         []Object
+	stack       []Object
+	mem         Memory
+	heap        *Heap // 最初に使うときに作る
+	heapBase    int   // これより前のメモリはヒープにしない
+	gcStats     GCStats
+	consts      ConstantPool // Loadしたプログラムの文字列リテラル
+	stdin       *bufio.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+	hostFuncs   []hostFunc    // 登録順
+	started     bool          // StepやRunで実行を始めたか
+	breakpoints map[int]bool  // 命令のpc
+	trace       *tracer       // nilならトレースしない
+	prof        *Profiler     // nilなら数えない
+	fuel        int           // 0なら制限しない
+	executed    int           // startしてから実行した命令の数
+	debug       *DebugInfo    // nilならソースの位置は分からない
+	code        []instruction // Runのたびにprogramを解読して作る
+	verify      bool          // LoadでVerifyするか
+	sched       *scheduler    // 最初にSpawnするまでnil
+	timeSlice   int           // 0ならdefaultTimeSlice
+	pcg         *rand.PCG     // nilならタイムスライスは一定
 }
 
-func NewRuntime(stackSize, memSize int) *Runtime {
+func NewRuntime(stackSize, memSize int, opts ...Option) *Runtime {
 	r := &Runtime{
 		program: nil,
 		sym:     *NewSymbolTable(),
@@ -25,23 +50,33 @@ func NewRuntime(stackSize, memSize int) *Runtime {
 	r.setSp(stackSize - 1)
 	r.setPc(0)
 	r.setBp(0)
+	for _, opt := range opts {
+		opt(r)
+	}
 	return r
 }
 
-func (r *Runtime) Load(program Program) {
-	// 擬似的なプロセス呼び出し用コード
-	// これがないとmainでretを使えなくなる
-	startup := Program{
-		// root(l_-1):
-		//   call main(l_0)
-		//   exit
-		DefLabel(-1),
-		Call, Label(0),
-		Exit,
+// 擬似的なプロセス呼び出し用コード
+// これがないとmainでretを使えなくなる
+var startup = Program{
+	// root(l_-1):
+	//   call main(l_0)
+	//   exit
+	DefLabel(-1),
+	Call, Label(0),
+	Exit,
+}
+
+// Load プログラムを読み込む, WithVerifyを指定していれば先にVerifyで確かめる
+func (r *Runtime) Load(program Program) error {
+	if r.verify {
+		if err := Verify(program); err != nil {
+			return err
+		}
 	}
-	program = append(startup, program...)
-	r.program = program
-	return
+	program = append(append(Program{}, startup...), program...)
+	r.program, r.consts = internConstants(program)
+	return nil
 }
 
 func (r *Runtime) CollectLabels() error {
@@ -59,24 +94,113 @@ func (r *Runtime) CollectLabels() error {
 }
 
 func (r *Runtime) Run() error {
-	// 擬似的なプロセスからの呼び出し
+	return r.RunContext(context.Background())
+}
+
+// start 擬似的なプロセスの先頭から実行できるようにする
+func (r *Runtime) start() error {
 	entryPoint, err := r.sym.Get(Label(-1))
 	if err != nil {
 		return err
 	}
+	r.resetThreads()
+	r.reg[ExitFlag] = nil // 前に最後まで実行していても先頭からやり直す
 	r.setPc(entryPoint.Value())
-	//
+	r.started = true
+	r.executed = 0
+	if err := r.skipLabels(); err != nil {
+		return r.fail(entryPoint.Value(), Nop, err)
+	}
+	return nil
+}
+
+// step 命令を1つ実行する
+// 失敗したときは*RuntimeErrorを返し, pcは失敗した命令を指したままにする
+func (r *Runtime) step() error {
+	pc := r.pc()
+	if err := r.skipLabels(); err != nil {
+		return r.fail(pc, Nop, err)
+	}
+	pc = r.pc()
+	op := r.program[pc].(Opcode)
+	if 0 < r.fuel && r.fuel <= r.executed {
+		return &HaltError{Pc: pc, Executed: r.executed, Err: ErrFuelExhausted}
+	}
+	if err := r.checkOperands(pc, op); err != nil {
+		return r.fail(pc, op, err)
+	}
+	if r.trace != nil {
+		if err := r.emitTrace(); err != nil {
+			return err
+		}
+	}
+	if r.prof != nil {
+		r.prof.observe(r)
+	}
+	bp, sp := r.reg[BasePointer], r.reg[StackPointer]
+	if err := r.do(); err != nil {
+		return r.fail(pc, op, err)
+	}
+	if err := r.checkPointers(); err != nil { // 書き込む前に戻して, 失敗した命令から見直せるようにする
+		r.reg[BasePointer], r.reg[StackPointer] = bp, sp
+		return r.fail(pc, op, err)
+	}
+	r.executed++
+	if r.sched != nil {
+		if err := r.schedule(); err != nil {
+			return r.fail(pc, op, err)
+		}
+	}
+	if r.mustExit() {
+		return nil
+	}
+	if err := r.skipLabels(); err != nil {
+		return r.fail(pc, op, err)
+	}
+	return nil
+}
+
+// checkOperands 命令のオペランドが揃っていて, レジスタを指すものが正しいか調べる
+func (r *Runtime) checkOperands(pc int, op Opcode) error {
+	n := Operand(op)
+	if len(r.program) <= pc+n {
+		return errorf(PcOutOfRange, "%v takes %d operands, but program ends at %d", op, n, len(r.program))
+	}
+	for _, obj := range r.program[pc+1 : pc+1+n] {
+		var reg Register
+		switch obj := obj.(type) {
+		case nil:
+			return errorf(BadOperand, "nil operand")
+		case Register:
+			reg = obj
+		case StackRelativeOffset:
+			if obj.target != BasePointer && obj.target != StackPointer {
+				return errorf(BadOperand, "unsupported offset: %v", obj)
+			}
+			continue
+		case MemoryRelativeOffset:
+			reg = obj.target
+		default:
+			continue
+		}
+		if reg < 0 || _reg_end <= reg {
+			return errorf(BadOperand, "invalid register: %d", int(reg))
+		}
+	}
+	return nil
+}
+
+// skipLabels ラベル定義を読み飛ばして次の命令まで進める
+func (r *Runtime) skipLabels() error {
 	for {
-		if r.mustExit() {
-			return nil
+		if r.pc() < 0 || len(r.program) <= r.pc() {
+			return errorf(PcOutOfRange, "pc out of range: %d", r.pc())
 		}
 		switch code := r.program[r.pc()]; code.(type) {
 		case DefLabel:
-			r.incPc() // ラベル定義を読み飛ばす
+			r.incPc()
 		case Opcode:
-			if err := r.do(); err != nil {
-				return err
-			}
+			return nil
 		default:
 			return fmt.Errorf("unsupported code: %v", code)
 		}
@@ -84,6 +208,9 @@ func (r *Runtime) Run() error {
 }
 
 func (r *Runtime) Status() int {
+	if r.reg[ACM1] == nil { // 終了コードを設定していない
+		return 0
+	}
 	return r.reg[ACM1].Value()
 }
 
@@ -103,7 +230,7 @@ func (r *Runtime) incPc() {
 
 // ベースポインター
 func (r *Runtime) bp() int {
-	return r.reg[BasePointer].Value()
+	return r.pointer(BasePointer)
 }
 func (r *Runtime) setBp(bp int) {
 	r.reg[BasePointer] = Integer(bp)
@@ -111,22 +238,59 @@ func (r *Runtime) setBp(bp int) {
 
 // スタックポインター
 func (r *Runtime) sp() int {
-	return r.reg[StackPointer].Value()
+	return r.pointer(StackPointer)
 }
 func (r *Runtime) setSp(sp int) {
 	r.reg[StackPointer] = Integer(sp)
 }
 
+// pointer bpかspの値, stepが整数に保つが, 整数でなければ-1にしてスタックの外を指させる
+func (r *Runtime) pointer(reg Register) int {
+	if v, ok := r.reg[reg].(Integer); ok {
+		return int(v)
+	}
+	return -1
+}
+
+// checkPointers bpとspが整数であること, 整数でないと次にスタックを触る命令が壊れる
+func (r *Runtime) checkPointers() error {
+	for _, reg := range []Register{BasePointer, StackPointer} {
+		if _, ok := r.reg[reg].(Integer); !ok {
+			return errorf(BadOperand, "%v must be an integer, but got: %v", reg, r.reg[reg])
+		}
+	}
+	return nil
+}
+
 // offsetの計算
-func (r *Runtime) calcOffset(offset StackRelativeOffset) int {
-	switch offset.target {
-	case StackPointer:
-		return r.sp() + offset.relativeDistance
-	case BasePointer:
-		return r.bp() + offset.relativeDistance
-	default:
-		panic(fmt.Sprintf("unsupported offset: %v", offset))
+func (r *Runtime) stackIndex(offset StackRelativeOffset) (int, error) {
+	if offset.target != BasePointer && offset.target != StackPointer {
+		return 0, errorf(BadOperand, "unsupported offset: %v", offset)
+	}
+	base, ok := r.reg[offset.target].(Integer)
+	if !ok {
+		return 0, errorf(BadOperand, "%v must be an integer, but got: %v", offset.target, r.reg[offset.target])
 	}
+	i := int(base) + offset.relativeDistance
+	if i < 0 || len(r.stack) <= i {
+		return 0, errorf(StackOutOfRange, "%v points outside of stack: stack_size=%d, access=%d", offset, len(r.stack), i)
+	}
+	return i, nil
+}
+func (r *Runtime) stackAt(offset StackRelativeOffset) (Object, error) {
+	i, err := r.stackIndex(offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.stack[i], nil
+}
+func (r *Runtime) setStackAt(offset StackRelativeOffset, obj Object) error {
+	i, err := r.stackIndex(offset)
+	if err != nil {
+		return err
+	}
+	r.stack[i] = obj
+	return nil
 }
 
 func (r *Runtime) isSameObjType(obj1, obj2 Object, deep bool) bool {
@@ -139,7 +303,7 @@ func (r *Runtime) isSameObjType(obj1, obj2 Object, deep bool) bool {
 	case Register:
 		lhs = r.reg[obj1.(Register)]
 	case StackRelativeOffset:
-		lhs = r.stack[r.calcOffset(obj1.(StackRelativeOffset))]
+		lhs, _ = r.stackAt(obj1.(StackRelativeOffset))
 	default:
 		lhs = obj1
 	}
@@ -147,36 +311,256 @@ func (r *Runtime) isSameObjType(obj1, obj2 Object, deep bool) bool {
 	case Register:
 		rhs = r.reg[obj2.(Register)]
 	case StackRelativeOffset:
-		rhs = r.stack[r.calcOffset(obj2.(StackRelativeOffset))]
+		rhs, _ = r.stackAt(obj2.(StackRelativeOffset))
 	default:
 		rhs = obj2
 	}
 	return reflect.TypeOf(lhs) == reflect.TypeOf(rhs)
 }
 
+// isListOffset list += intのような参照の移動か
+func (r *Runtime) isListOffset(dest, src Object) bool {
+	reg, ok := dest.(Register)
+	if !ok {
+		return false
+	}
+	if _, ok := r.reg[reg].(List); !ok {
+		return false
+	}
+	switch src.(type) {
+	case Integer:
+		return true
+	case Register:
+		_, ok := r.reg[src.(Register)].(Integer)
+		return ok
+	default:
+		return false
+	}
+}
+
+// withKind 参照を動かした結果は参照のまま
+func withKind(base Object, v int) Object {
+	if _, ok := base.(List); ok {
+		return List(v)
+	}
+	return Integer(v)
+}
+
+// memoryAddress メモリのオペランドから絶対位置を求める
+func (r *Runtime) memoryAddress(obj Object) (MemoryOffset, error) {
+	switch obj.(type) {
+	case MemoryOffset:
+		return obj.(MemoryOffset), nil
+	case MemoryRelativeOffset:
+		base := r.reg[obj.(MemoryRelativeOffset).target]
+		if base == nil {
+			return 0, fmt.Errorf("%v is empty", obj.(MemoryRelativeOffset).target)
+		}
+		return MemoryOffset(base.Value() + obj.Value()), nil
+	default:
+		return 0, fmt.Errorf("%v", obj)
+	}
+}
+
+// operandValue オペランドの値を取り出す, レジスタやスタックなら中身を返す
+func (r *Runtime) operandValue(obj Object) (Object, error) {
+	var v Object
+	switch obj.(type) {
+	case Register:
+		v = r.reg[obj.(Register)]
+	case StackRelativeOffset:
+		var err error
+		if v, err = r.stackAt(obj.(StackRelativeOffset)); err != nil {
+			return nil, err
+		}
+	case Integer, Float, Character, Bool, Null:
+		v = obj
+	case Constant:
+		var err error
+		if v, err = r.consts.Get(obj.(Constant)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v", obj)
+	}
+	if v == nil { // 何も入っていない
+		return nil, fmt.Errorf("%v is empty", obj)
+	}
+	return v, nil
+}
+
+// comparands 比較命令の左辺と右辺, レジスタと即値だけを受け付ける
+// 空のレジスタは等価比較ならnilのまま比べ, 大小比較ならエラーにする
+func (r *Runtime) comparands(op Opcode) (Object, Object, error) {
+	var operands [2]Object
+	for i, obj := range r.program[r.pc()+1 : r.pc()+3] {
+		var v Object
+		switch obj.(type) {
+		case Register:
+			v = r.reg[obj.(Register)]
+		case Integer, Float, Character, Bool, Null:
+			v = obj
+		case Constant:
+			var err error
+			if v, err = r.consts.Get(obj.(Constant)); err != nil {
+				return nil, nil, fmt.Errorf("unsupported %v value: %w", op, err)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported %v value: %v", op, obj)
+		}
+		if v == nil && op != Eq && op != Ne && op != Beq && op != Bne {
+			return nil, nil, fmt.Errorf("unsupported %v value: %v is empty", op, obj)
+		}
+		operands[i] = v
+	}
+	return operands[0], operands[1], nil
+}
+
+// compare 比較命令の結果
+// 等価比較は型も比べ, 大小比較は型が違っても値で比べる
+func compare(op Opcode, lhs, rhs Object) bool {
+	switch op {
+	case Eq, Beq:
+		return lhs == rhs
+	case Ne, Bne:
+		return lhs != rhs
+	}
+	var c int
+	_, lf := lhs.(Float)
+	_, rf := rhs.(Float)
+	ls, lok := lhs.(String)
+	rs, rok := rhs.(String)
+	if lf || rf { // どちらかが小数なら小数として比べる
+		c = cmp.Compare(toFloat(lhs), toFloat(rhs))
+	} else if lok && rok { // 文字列どうしは辞書順
+		c = cmp.Compare(ls, rs)
+	} else {
+		c = cmp.Compare(lhs.Value(), rhs.Value())
+	}
+	switch op {
+	case Lt, Blt:
+		return c < 0
+	case Le, Ble:
+		return c <= 0
+	case Gt, Bgt:
+		return c > 0
+	case Ge, Bge:
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+// toFloat 大小比較のために小数にする
+func toFloat(obj Object) float64 {
+	if f, ok := obj.(Float); ok {
+		return float64(f)
+	}
+	return float64(obj.Value())
+}
+
+// arithmetic 四則演算, 同じ型どうしであることは確認済み
+func arithmetic(op Opcode, lhs, rhs Object) (Object, error) {
+	if lhs == nil || rhs == nil {
+		return nil, errorf(BadOperand, "unsupported %v value: empty register", op)
+	}
+	if _, ok := lhs.(String); ok { // Valueは文字数なので計算できてしまう
+		return nil, errorf(BadOperand, "unsupported %v value: string, use Concat to join strings", op)
+	}
+	if l, ok := lhs.(Float); ok {
+		r := toFloat(rhs)
+		switch op {
+		case Add:
+			return l + Float(r), nil
+		case Sub:
+			return l - Float(r), nil
+		case Mul:
+			return l * Float(r), nil
+		case Div:
+			if r == 0 {
+				return nil, errorf(DivisionByZero, "division by zero")
+			}
+			return l / Float(r), nil
+		default:
+			return nil, fmt.Errorf("unsupported %v value", op)
+		}
+	}
+	l, r := lhs.Value(), rhs.Value()
+	switch op {
+	case Add:
+		return withKind(lhs, l+r), nil
+	case Sub:
+		return withKind(lhs, l-r), nil
+	case Mul:
+		return withKind(lhs, l*r), nil
+	case Div:
+		if r == 0 {
+			return nil, errorf(DivisionByZero, "division by zero")
+		}
+		return withKind(lhs, l/r), nil
+	case Mod:
+		if r == 0 {
+			return nil, errorf(DivisionByZero, "division by zero")
+		}
+		return withKind(lhs, l%r), nil
+	default:
+		return nil, fmt.Errorf("unsupported %v value", op)
+	}
+}
+
 // 終了フラグ
 func (r *Runtime) mustExit() bool {
 	return r.reg[ExitFlag] == True
 }
 
+// ##########
+// #ヒープ管理#
+// ##########
+// getHeap WithHeapBaseで決めた大域変数の領域より後ろをヒープにする
+func (r *Runtime) getHeap() *Heap {
+	if r.heap == nil {
+		base := min(r.heapBase, len(r.mem))
+		r.heap = NewHeapAt(base, len(r.mem)-base)
+	}
+	return r.heap
+}
+
+// HeapStats ヒープの使用状況と断片化の度合い
+func (r *Runtime) HeapStats() HeapStats {
+	return r.getHeap().Stats()
+}
+
 // ############
 // #スタック管理#
 // ############
-func (r *Runtime) push(obj Object) {
+func (r *Runtime) push(obj Object) error {
 	if obj == nil {
-		panic("nil pushed")
+		return errorf(BadOperand, "nil pushed")
 	}
-	r.setSp(r.sp() - 1)
-	if r.sp() < 0 {
-		panic(fmt.Sprintf("stack overflow: stack_size=%d, access=%d", len(r.stack), r.sp()))
+	sp := r.sp() - 1
+	if sp < 0 {
+		return errorf(StackOverflow, "stack overflow: stack_size=%d, access=%d", len(r.stack), sp)
 	}
-	r.stack[r.sp()] = obj
+	if len(r.stack) <= sp {
+		return errorf(StackOutOfRange, "sp points outside of stack: stack_size=%d, access=%d", len(r.stack), sp)
+	}
+	r.setSp(sp)
+	r.stack[sp] = obj
+	return nil
 }
-func (r *Runtime) pop() Object {
+
+// pop 一番下(len-1)は積んだものが入らないので, そこまで来たら空
+func (r *Runtime) pop() (Object, error) {
+	if r.sp() < 0 {
+		return nil, errorf(StackOutOfRange, "sp points outside of stack: stack_size=%d, access=%d", len(r.stack), r.sp())
+	}
+	if len(r.stack)-1 <= r.sp() {
+		return nil, errorf(StackUnderflow, "pop from empty stack: stack_size=%d, sp=%d", len(r.stack), r.sp())
+	}
 	v := r.stack[r.sp()]
 	r.stack[r.sp()] = nil
 	r.setSp(r.sp() + 1)
-	return v
+	return v, nil
 }
 
 // #####
@@ -195,14 +579,20 @@ func (r *Runtime) do() error {
 			if err != nil {
 				return err
 			}
-			r.push(ProgramAbsoluteOffset(r.pc() + 1 + Operand(Call))) // 戻る場所はoffsetとして与える
+			// 戻る場所はoffsetとして与える
+			if err := r.push(ProgramAbsoluteOffset(r.pc() + 1 + Operand(Call))); err != nil {
+				return err
+			}
 			r.setPc(dest.Value())
 			return nil
 		default:
 			return fmt.Errorf("unsupported call dest: %v", fnLabel)
 		}
 	case Ret: // RET
-		dest := r.pop()
+		dest, err := r.pop()
+		if err != nil {
+			return err
+		}
 		switch dest.(type) {
 		case ProgramAbsoluteOffset: // offsetが入っているはず
 			r.setPc(dest.Value())
@@ -268,25 +658,43 @@ func (r *Runtime) do() error {
 				r.reg[dest.(Register)] = r.reg[src.(Register)]
 				return nil
 			case StackRelativeOffset: // reg <- offset
-				r.reg[dest.(Register)] = r.stack[r.calcOffset(src.(StackRelativeOffset))]
+				v, err := r.stackAt(src.(StackRelativeOffset))
+				if err != nil {
+					return err
+				}
+				r.reg[dest.(Register)] = v
 				return nil
-			case Integer, Character, Bool, Null:
+			case Integer, Float, Character, Bool, Null:
 				r.reg[dest.(Register)] = src
 				return nil
+			case Constant:
+				v, err := r.consts.Get(src.(Constant))
+				if err != nil {
+					return fmt.Errorf("unsupported mov src: %w", err)
+				}
+				r.reg[dest.(Register)] = v
+				return nil
 			default:
 				return fmt.Errorf("unsupported mov src: %v", src)
 			}
 		case StackRelativeOffset:
 			switch src.(type) {
 			case Register:
-				r.stack[r.calcOffset(dest.(StackRelativeOffset))] = r.reg[src.(Register)]
-				return nil
+				return r.setStackAt(dest.(StackRelativeOffset), r.reg[src.(Register)])
 			case StackRelativeOffset:
-				r.stack[r.calcOffset(dest.(StackRelativeOffset))] = r.stack[r.calcOffset(src.(StackRelativeOffset))]
-				return nil
-			case Integer, Character, Bool, Null:
-				r.stack[r.calcOffset(dest.(StackRelativeOffset))] = src
-				return nil
+				v, err := r.stackAt(src.(StackRelativeOffset))
+				if err != nil {
+					return err
+				}
+				return r.setStackAt(dest.(StackRelativeOffset), v)
+			case Integer, Float, Character, Bool, Null:
+				return r.setStackAt(dest.(StackRelativeOffset), src)
+			case Constant:
+				v, err := r.consts.Get(src.(Constant))
+				if err != nil {
+					return fmt.Errorf("unsupported mov src: %w", err)
+				}
+				return r.setStackAt(dest.(StackRelativeOffset), v)
 			}
 			return fmt.Errorf("unsupported mov dest: %v", dest)
 		default:
@@ -297,16 +705,23 @@ func (r *Runtime) do() error {
 		switch src := r.program[r.pc()+1]; src.(type) {
 		case Register:
 			//log.Printf("push reg: %v = %v\n", src.String(), r.reg[src.(Register)].String())
-			r.push(r.reg[src.(Register)])
-			return nil
+			return r.push(r.reg[src.(Register)])
 		case StackRelativeOffset:
 			//log.Println("push offset")
-			r.push(r.stack[r.calcOffset(src.(StackRelativeOffset))])
-			return nil
-		case Integer, Character, Bool, Null:
+			v, err := r.stackAt(src.(StackRelativeOffset))
+			if err != nil {
+				return err
+			}
+			return r.push(v)
+		case Integer, Float, Character, Bool, Null:
 			//log.Println("push primitive")
-			r.push(src)
-			return nil
+			return r.push(src)
+		case Constant:
+			v, err := r.consts.Get(src.(Constant))
+			if err != nil {
+				return fmt.Errorf("unsupported push src: %w", err)
+			}
+			return r.push(v)
 		default:
 			return fmt.Errorf("unsupported push src: %v", src)
 		}
@@ -314,240 +729,340 @@ func (r *Runtime) do() error {
 		defer func() { r.setPc(r.pc() + 1 + Operand(Pop)) }()
 		switch dest := r.program[r.pc()+1]; dest.(type) {
 		case Register:
-			r.reg[dest.(Register)] = r.pop()
+			v, err := r.pop()
+			if err != nil {
+				return err
+			}
+			r.reg[dest.(Register)] = v
 			return nil
 		default:
 			return fmt.Errorf("unsupported pop dest: %v", dest)
 		}
-	case Add:
+	case Add, Sub, Mul, Div, Mod:
 		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
 		dest := r.program[r.pc()+1]
 		src := r.program[r.pc()+2]
-		if !r.isSameObjType(dest, src, true) {
-			return fmt.Errorf("unsupported add match: %v-=%v", dest, src)
+		listOffset := (code == Add || code == Sub) && r.isListOffset(dest, src)
+		if !r.isSameObjType(dest, src, true) && !listOffset {
+			return fmt.Errorf("unsupported %v match: %v, %v", code, dest, src)
 		}
 		switch dest.(type) {
 		case Register:
+			var rhs Object
 			switch src.(type) {
-			case Integer: // reg += int
-				r.reg[dest.(Register)] = Integer(r.reg[dest.(Register)].Value() + src.Value())
-				return nil
+			case Integer, Float: // reg += int
+				rhs = src
 			case Register:
-				r.reg[dest.(Register)] = Integer(r.reg[dest.(Register)].Value() + r.reg[src.(Register)].Value())
-				return nil
+				rhs = r.reg[src.(Register)]
 			default:
-				return fmt.Errorf("unsupported add src: %v", src)
+				return fmt.Errorf("unsupported %v src: %v", code, src)
 			}
+			v, err := arithmetic(code.(Opcode), r.reg[dest.(Register)], rhs)
+			if err != nil {
+				return fmt.Errorf("%w: %v, %v", err, dest, src)
+			}
+			r.reg[dest.(Register)] = v
+			return nil
 		default:
-			return fmt.Errorf("unsupported add dest: %v", dest)
+			return fmt.Errorf("unsupported %v dest: %v", code, dest)
 		}
-	case Sub:
+	case And, Or, Xor, Shl, Shr:
 		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
 		dest := r.program[r.pc()+1]
 		src := r.program[r.pc()+2]
 		if !r.isSameObjType(dest, src, true) {
-			return fmt.Errorf("unsupported sub match: %v-=%v", dest, src)
+			return fmt.Errorf("unsupported %v match: %v, %v", code, dest, src)
 		}
 		switch dest.(type) {
 		case Register:
+			var rhs Object
 			switch src.(type) {
-			case Integer: // reg -= int
-				r.reg[dest.(Register)] = Integer(r.reg[dest.(Register)].Value() - src.Value())
-				return nil
+			case Integer, Bool:
+				rhs = src
 			case Register:
-				r.reg[dest.(Register)] = Integer(r.reg[dest.(Register)].Value() - r.reg[src.(Register)].Value())
-				return nil
+				rhs = r.reg[src.(Register)]
 			default:
-				return fmt.Errorf("unsupported sub src: %v", src)
+				return fmt.Errorf("unsupported %v src: %v", code, src)
 			}
-		default:
-			return fmt.Errorf("unsupported sub dest: %v", dest)
-		}
-	case Eq:
-		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
-		lhs := r.program[r.pc()+1]
-		rhs := r.program[r.pc()+2]
-		switch lhs.(type) {
-		case Register:
-			switch rhs.(type) {
-			case Register: // reg vs reg
-				//log.Printf("eq %v == %v\n", r.reg[lhs.(Register)].String(), r.reg[rhs.(Register)].String())
-				if r.reg[lhs.(Register)] == r.reg[rhs.(Register)] {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
+			switch lhs := r.reg[dest.(Register)]; lhs.(type) {
+			case Integer:
+				switch code.(Opcode) {
+				case And: // reg &= int
+					r.reg[dest.(Register)] = Integer(lhs.Value() & rhs.Value())
+				case Or: // reg |= int
+					r.reg[dest.(Register)] = Integer(lhs.Value() | rhs.Value())
+				case Xor: // reg ^= int
+					r.reg[dest.(Register)] = Integer(lhs.Value() ^ rhs.Value())
+				case Shl, Shr:
+					if rhs.Value() < 0 {
+						return fmt.Errorf("negative shift count: %v", rhs)
+					}
+					if 64 <= rhs.Value() { // 整数は64bitなので全部押し出される
+						return fmt.Errorf("shift count too large: %v", rhs)
+					}
+					if code.(Opcode) == Shl { // reg <<= int
+						r.reg[dest.(Register)] = Integer(lhs.Value() << rhs.Value())
+					} else { // reg >>= int, 符号は保たれる
+						r.reg[dest.(Register)] = Integer(lhs.Value() >> rhs.Value())
+					}
 				}
 				return nil
-			case Integer, Character, Bool, Null: // reg vs int
-				if r.reg[lhs.(Register)] == rhs {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
+			case String:
+				return errorf(BadOperand, "unsupported %v value: string, use Concat to join strings", code)
+			case Bool:
+				switch code.(Opcode) {
+				case And: // reg = reg && bool
+					r.reg[dest.(Register)] = Bool(lhs == True && rhs == True)
+				case Or: // reg = reg || bool
+					r.reg[dest.(Register)] = Bool(lhs == True || rhs == True)
+				default:
+					return fmt.Errorf("unsupported %v value: %v", code, lhs)
 				}
 				return nil
 			default:
-				return fmt.Errorf("unsupported eq value: %v == %v", lhs, rhs)
+				return fmt.Errorf("unsupported %v value: %v", code, lhs)
 			}
-		case Integer, Character, Bool, Null:
-			switch rhs.(type) {
-			case Register:
-				if lhs == r.reg[rhs.(Register)] {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
+		default:
+			return fmt.Errorf("unsupported %v dest: %v", code, dest)
+		}
+	case Not:
+		defer func() { r.setPc(r.pc() + 1 + Operand(Not)) }()
+		switch dest := r.program[r.pc()+1]; dest.(type) {
+		case Register:
+			switch v := r.reg[dest.(Register)]; v.(type) {
+			case Integer: // reg = ^reg
+				r.reg[dest.(Register)] = Integer(^v.Value())
 				return nil
-			case Integer, Character, Bool, Null:
-				if lhs == rhs {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
+			case Bool: // reg = !reg
+				r.reg[dest.(Register)] = Bool(v != True)
 				return nil
 			default:
-				return fmt.Errorf("unsupported eq value: %v == %v", lhs, rhs)
+				return fmt.Errorf("unsupported not value: %v", v)
 			}
 		default:
-			return fmt.Errorf("unsupported eq value: %v == %v", lhs, rhs)
+			return fmt.Errorf("unsupported not dest: %v", dest)
 		}
-	case Ne:
-		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
-		lhs := r.program[r.pc()+1]
-		rhs := r.program[r.pc()+2]
-		switch lhs.(type) {
+	case Load: // LOAD REG MEM
+		defer func() { r.setPc(r.pc() + 1 + Operand(Load)) }()
+		dest := r.program[r.pc()+1]
+		src := r.program[r.pc()+2]
+		addr, err := r.memoryAddress(src)
+		if err != nil {
+			return fmt.Errorf("unsupported load src: %w", err)
+		}
+		switch dest.(type) {
 		case Register:
-			switch rhs.(type) {
-			case Register: // reg vs reg
-				if r.reg[lhs.(Register)] != r.reg[rhs.(Register)] {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			case Integer, Character, Bool, Null: // reg vs int
-				if r.reg[lhs.(Register)] != rhs {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			default:
-				return fmt.Errorf("unsupported ne value: %v == %v", lhs, rhs)
+			v, err := r.mem.Get(addr)
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %w", src, err)
 			}
-		case Integer, Character, Bool, Null:
-			switch rhs.(type) {
-			case Register:
-				if lhs != r.reg[rhs.(Register)] {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			case Integer, Character, Bool, Null:
-				if lhs != rhs {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			default:
-				return fmt.Errorf("unsupported ne value: %v == %v", lhs, rhs)
+			if v == nil { // 何も書き込まれていない
+				v = Null{}
 			}
+			r.reg[dest.(Register)] = v
+			return nil
 		default:
-			return fmt.Errorf("unsupported ne value: %v == %v", lhs, rhs)
+			return fmt.Errorf("unsupported load dest: %v", dest)
 		}
-	case Lt:
-		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
-		lhs := r.program[r.pc()+1]
-		rhs := r.program[r.pc()+2]
-		switch lhs.(type) {
+	case Store: // STORE MEM SRC
+		defer func() { r.setPc(r.pc() + 1 + Operand(Store)) }()
+		dest := r.program[r.pc()+1]
+		src := r.program[r.pc()+2]
+		addr, err := r.memoryAddress(dest)
+		if err != nil {
+			return fmt.Errorf("unsupported store dest: %w", err)
+		}
+		var v Object
+		switch src.(type) {
 		case Register:
-			switch rhs.(type) {
-			case Register: // reg vs reg
-				if r.reg[lhs.(Register)].Value() < r.reg[rhs.(Register)].Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			case Integer, Character, Bool, Null: // reg vs int
-				if r.reg[lhs.(Register)].Value() < rhs.Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			default:
-				return fmt.Errorf("unsupported lt value: %v == %v", lhs, rhs)
+			v = r.reg[src.(Register)]
+		case StackRelativeOffset:
+			if v, err = r.stackAt(src.(StackRelativeOffset)); err != nil {
+				return err
 			}
-		case Integer, Character, Bool, Null:
-			switch rhs.(type) {
-			case Register:
-				if lhs.Value() < r.reg[rhs.(Register)].Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			case Integer, Character, Bool, Null:
-				if lhs.Value() < rhs.Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
-				return nil
-			default:
-				return fmt.Errorf("unsupported lt value: %v == %v", lhs, rhs)
+		case Integer, Float, Character, Bool, Null:
+			v = src
+		case Constant:
+			v, err = r.consts.Get(src.(Constant))
+			if err != nil {
+				return fmt.Errorf("unsupported store src: %w", err)
 			}
 		default:
-			return fmt.Errorf("unsupported lt value: %v == %v", lhs, rhs)
+			return fmt.Errorf("unsupported store src: %v", src)
+		}
+		if err := r.mem.Set(addr, v); err != nil {
+			return fmt.Errorf("failed to store %v: %w", dest, err)
 		}
-	case Le:
+		return nil
+	case Eq, Ne, Lt, Le, Gt, Ge:
 		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
-		lhs := r.program[r.pc()+1]
-		rhs := r.program[r.pc()+2]
-		switch lhs.(type) {
+		lhs, rhs, err := r.comparands(code.(Opcode))
+		if err != nil {
+			return err
+		}
+		r.reg[ZeroFlag] = Bool(compare(code.(Opcode), lhs, rhs))
+		return nil
+	case Beq, Bne, Blt, Ble, Bgt, Bge: // Bxx LHS RHS LABEL
+		lhs, rhs, err := r.comparands(code.(Opcode))
+		if err != nil {
+			return err
+		}
+		destLabel, ok := r.program[r.pc()+3].(Label)
+		if !ok {
+			return fmt.Errorf("unsupported %v dest: want label, but got: %v", code, r.program[r.pc()+3])
+		}
+		dest, err := r.sym.Get(destLabel)
+		if err != nil {
+			return err
+		}
+		if compare(code.(Opcode), lhs, rhs) {
+			r.setPc(dest.Value())
+			return nil
+		}
+		r.setPc(r.pc() + 1 + Operand(code.(Opcode)))
+		return nil
+	case Neg:
+		defer func() { r.setPc(r.pc() + 1 + Operand(Neg)) }()
+		switch dest := r.program[r.pc()+1]; dest.(type) {
 		case Register:
-			switch rhs.(type) {
-			case Register: // reg vs reg
-				if r.reg[lhs.(Register)].Value() <= r.reg[rhs.(Register)].Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
+			switch v := r.reg[dest.(Register)]; v.(type) {
+			case Integer: // reg = -reg
+				r.reg[dest.(Register)] = Integer(-v.Value())
 				return nil
-			case Integer, Character, Bool, Null: // reg vs int
-				if r.reg[lhs.(Register)].Value() <= rhs.Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
+			case Float:
+				r.reg[dest.(Register)] = -v.(Float)
 				return nil
 			default:
-				return fmt.Errorf("unsupported le value: %v == %v", lhs, rhs)
+				return fmt.Errorf("unsupported neg value: %v", v)
 			}
-		case Integer, Character, Bool, Null:
-			switch rhs.(type) {
-			case Register:
-				if lhs.Value() <= r.reg[rhs.(Register)].Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
-				}
+		default:
+			return fmt.Errorf("unsupported neg dest: %v", dest)
+		}
+	case Itof:
+		defer func() { r.setPc(r.pc() + 1 + Operand(Itof)) }()
+		switch dest := r.program[r.pc()+1]; dest.(type) {
+		case Register:
+			switch v := r.reg[dest.(Register)]; v.(type) {
+			case Integer: // reg = float(reg)
+				r.reg[dest.(Register)] = Float(v.Value())
 				return nil
-			case Integer, Character, Bool, Null:
-				if lhs.Value() <= rhs.Value() {
-					r.reg[ZeroFlag] = True
-				} else {
-					r.reg[ZeroFlag] = False
+			default:
+				return fmt.Errorf("unsupported itof value: %v", v)
+			}
+		default:
+			return fmt.Errorf("unsupported itof dest: %v", dest)
+		}
+	case Ftoi:
+		defer func() { r.setPc(r.pc() + 1 + Operand(Ftoi)) }()
+		switch dest := r.program[r.pc()+1]; dest.(type) {
+		case Register:
+			switch v := r.reg[dest.(Register)]; v.(type) {
+			case Float: // reg = int(reg), 0の方向に切り捨て
+				f := float64(v.(Float))
+				if math.IsNaN(f) || f < math.MinInt64 || math.MaxInt64 <= f { // 無限大も含めて整数で表せない
+					return fmt.Errorf("unsupported ftoi value: %v, out of integer range", v)
 				}
+				r.reg[dest.(Register)] = Integer(v.Value())
 				return nil
 			default:
-				return fmt.Errorf("unsupported le value: %v == %v", lhs, rhs)
+				return fmt.Errorf("unsupported ftoi value: %v", v)
 			}
 		default:
-			return fmt.Errorf("unsupported le value: %v == %v", lhs, rhs)
+			return fmt.Errorf("unsupported ftoi dest: %v", dest)
+		}
+	case Concat: // CONCAT REG SRC
+		defer func() { r.setPc(r.pc() + 1 + Operand(Concat)) }()
+		dest, ok := r.program[r.pc()+1].(Register)
+		if !ok {
+			return fmt.Errorf("unsupported concat dest: %v", r.program[r.pc()+1])
+		}
+		lhs, ok := r.reg[dest].(String)
+		if !ok {
+			return fmt.Errorf("unsupported concat value: %v", r.reg[dest])
+		}
+		rhs, err := r.operandValue(r.program[r.pc()+2])
+		if err != nil {
+			return fmt.Errorf("unsupported concat src: %w", err)
+		}
+		switch rhs.(type) {
+		case String, Character: // 文字もそのまま後ろに付けられる
+			r.reg[dest] = lhs + String(rhs.String())
+			return nil
+		default:
+			return fmt.Errorf("unsupported concat src: %v", rhs)
+		}
+	case Len: // LEN REG SRC
+		defer func() { r.setPc(r.pc() + 1 + Operand(Len)) }()
+		dest, ok := r.program[r.pc()+1].(Register)
+		if !ok {
+			return fmt.Errorf("unsupported len dest: %v", r.program[r.pc()+1])
+		}
+		src, err := r.operandValue(r.program[r.pc()+2])
+		if err != nil {
+			return fmt.Errorf("unsupported len src: %w", err)
+		}
+		if _, ok := src.(String); !ok {
+			return fmt.Errorf("unsupported len src: %v", src)
+		}
+		r.reg[dest] = Integer(src.Value())
+		return nil
+	case Index: // INDEX REG SRC IDX
+		defer func() { r.setPc(r.pc() + 1 + Operand(Index)) }()
+		dest, ok := r.program[r.pc()+1].(Register)
+		if !ok {
+			return fmt.Errorf("unsupported index dest: %v", r.program[r.pc()+1])
+		}
+		src, err := r.operandValue(r.program[r.pc()+2])
+		if err != nil {
+			return fmt.Errorf("unsupported index src: %w", err)
+		}
+		idx, err := r.operandValue(r.program[r.pc()+3])
+		if err != nil {
+			return fmt.Errorf("unsupported index idx: %w", err)
+		}
+		s, ok := src.(String)
+		if !ok {
+			return fmt.Errorf("unsupported index src: %v", src)
+		}
+		if _, ok := idx.(Integer); !ok {
+			return fmt.Errorf("unsupported index idx: %v", idx)
+		}
+		c, err := s.At(idx.Value())
+		if err != nil {
+			return err
+		}
+		r.reg[dest] = c
+		return nil
+	case Substr: // SUBSTR REG START END
+		defer func() { r.setPc(r.pc() + 1 + Operand(Substr)) }()
+		dest, ok := r.program[r.pc()+1].(Register)
+		if !ok {
+			return fmt.Errorf("unsupported substr dest: %v", r.program[r.pc()+1])
+		}
+		s, ok := r.reg[dest].(String)
+		if !ok {
+			return fmt.Errorf("unsupported substr value: %v", r.reg[dest])
+		}
+		start, err := r.operandValue(r.program[r.pc()+2])
+		if err != nil {
+			return fmt.Errorf("unsupported substr start: %w", err)
+		}
+		end, err := r.operandValue(r.program[r.pc()+3])
+		if err != nil {
+			return fmt.Errorf("unsupported substr end: %w", err)
 		}
+		if _, ok := start.(Integer); !ok {
+			return fmt.Errorf("unsupported substr start: %v", start)
+		}
+		if _, ok := end.(Integer); !ok {
+			return fmt.Errorf("unsupported substr end: %v", end)
+		}
+		sub, err := s.Slice(start.Value(), end.Value())
+		if err != nil {
+			return err
+		}
+		r.reg[dest] = sub
+		return nil
 	case Syscall:
 		defer func() { r.setPc(r.pc() + 1 + Operand(code.(Opcode))) }()
 		syscallNo := r.program[r.pc()+1]   // Write, ...
@@ -557,32 +1072,138 @@ func (r *Runtime) do() error {
 		case SystemCall:
 			switch syscallNo.(SystemCall) {
 			case Write:
-				var f *os.File
-				switch syscallArg1.(StandardIO) {
-				case StdIn:
-					panic(fmt.Errorf("unsupported syscall write dest: %v", StdIn))
-				case StdOut:
-					f = os.Stdout
-				case StdErr:
-					f = os.Stderr
+				std, _ := syscallArg1.(StandardIO)
+				f, ok := r.writer(std)
+				if !ok {
+					return errorf(BadOperand, "unsupported syscall write dest: %v", syscallArg1)
 				}
 				switch syscallArg2.(type) {
-				case Register:
-					_, err := fmt.Fprintf(f, r.reg[syscallArg2.(Register)].String())
+				case Register, StackRelativeOffset:
+					v, err := r.operandValue(syscallArg2)
+					if err != nil {
+						return errorf(BadOperand, "unsupported syscall write src: %v", err)
+					}
+					_, err = fmt.Fprint(f, v.String())
 					return err
-				case StackRelativeOffset:
-					_, err := fmt.Fprintf(f, r.stack[r.calcOffset(syscallArg2.(StackRelativeOffset))].String())
+				case Constant:
+					v, err := r.consts.Get(syscallArg2.(Constant))
+					if err != nil {
+						return fmt.Errorf("unsupported syscall write src: %w", err)
+					}
+					_, err = fmt.Fprint(f, v.String())
 					return err
 				default:
-					_, err := fmt.Fprintf(f, syscallArg2.String())
+					_, err := fmt.Fprint(f, syscallArg2.String())
 					return err
 				}
-			default:
-				return fmt.Errorf("unsupported syscall number: %v", syscallNo)
+			case Alloc: // SYSCALL ALLOC REG SIZE
+				dest, ok := syscallArg1.(Register)
+				if !ok {
+					return errorf(BadOperand, "unsupported alloc dest: %v", syscallArg1)
+				}
+				size, err := r.operandValue(syscallArg2)
+				if err != nil {
+					return fmt.Errorf("unsupported alloc size: %w", err)
+				}
+				if _, ok := size.(Integer); !ok {
+					return errorf(BadOperand, "unsupported alloc size: %v", size)
+				}
+				addr, err := r.getHeap().Alloc(size.Value())
+				if err != nil { // 足りなければ回収してもう一度
+					r.GC()
+					if addr, err = r.getHeap().Alloc(size.Value()); err != nil {
+						return err
+					}
+				}
+				r.reg[dest] = List(addr)
+				return nil
+			case Free: // SYSCALL FREE LIST NULL
+				ref, err := r.operandValue(syscallArg1)
+				if err != nil {
+					return fmt.Errorf("unsupported free target: %w", err)
+				}
+				if _, ok := ref.(List); !ok {
+					return errorf(BadOperand, "unsupported free target: %v", ref)
+				}
+				size, err := r.getHeap().Free(ref.Value())
+				if err != nil {
+					return err
+				}
+				for i := ref.Value(); i < ref.Value()+size; i++ {
+					r.mem.Delete(MemoryOffset(i))
+				}
+				return nil
+			case GarbageCollect: // SYSCALL GC NULL NULL
+				r.GC()
+				return nil
+			case ReadChar, ReadLine, ReadInt: // SYSCALL READ STDIN REG
+				if syscallArg1 != StdIn {
+					return errorf(BadOperand, "unsupported syscall %v src: %v", syscallNo, syscallArg1)
+				}
+				dest, ok := syscallArg2.(Register)
+				if !ok {
+					return errorf(BadOperand, "unsupported syscall %v dest: %v", syscallNo, syscallArg2)
+				}
+				v, err := r.read(syscallNo.(SystemCall))
+				if err != nil {
+					return err
+				}
+				r.reg[dest] = v
+				return nil
+			default: // 組み込みになければホスト関数
+				h, err := r.hostFunc(syscallNo)
+				if err != nil {
+					return err
+				}
+				return r.callHost(h, syscallArg1, syscallArg2)
 			}
+		case Constant: // 名前で呼ぶホスト関数
+			h, err := r.hostFunc(syscallNo)
+			if err != nil {
+				return err
+			}
+			return r.callHost(h, syscallArg1, syscallArg2)
 		default:
-			return fmt.Errorf("unsupported syscall want type(syscall), but got: %v", syscallNo)
+			return errorf(BadOperand, "unsupported syscall want type(syscall), but got: %v", syscallNo)
+		}
+	case Spawn: // SPAWN LABEL
+		defer func() { r.setPc(r.pc() + 1 + Operand(Spawn)) }()
+		label, ok := r.program[r.pc()+1].(Label)
+		if !ok {
+			return errorf(BadOperand, "unsupported spawn dest: want label, but got: %v", r.program[r.pc()+1])
 		}
+		dest, err := r.sym.Get(label)
+		if err != nil {
+			return err
+		}
+		pc := r.program.firstOpcode(dest.Value())
+		if pc < 0 {
+			return errorf(PcOutOfRange, "%v has no instruction", label)
+		}
+		id, err := r.spawn(label, pc)
+		if err != nil {
+			return err
+		}
+		r.reg[R10] = Integer(id)
+		return nil
+	case Yield: // YIELD
+		r.setPc(r.pc() + 1 + Operand(Yield))
+		if r.sched != nil {
+			r.sched.yield = true
+		}
+		return nil
+	case Join: // JOIN THREAD
+		id, err := r.operandValue(r.program[r.pc()+1])
+		if err != nil {
+			return fmt.Errorf("unsupported join target: %w", err)
+		}
+		v, ok, err := r.join(id)
+		if err != nil || !ok { // 終わるまでpcを進めずに待つ
+			return err
+		}
+		r.reg[R10] = v
+		r.setPc(r.pc() + 1 + Operand(Join))
+		return nil
 	default:
 		return fmt.Errorf("unsupported opcode: %v", code)
 	}
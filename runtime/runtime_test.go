@@ -2,8 +2,9 @@ package runtime
 
 import (
 	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
-	"os"
+	"math"
 	"strings"
 	"testing"
 )
@@ -177,6 +178,253 @@ func TestRuntime_Run_Sub(t *testing.T) {
 	assert.Equal(t, Integer(2), rt.reg[General1])
 }
 
+func TestRuntime_Run_Mul(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(3),
+		Mov, General2, Integer(-4),
+		Mul, General1, Integer(5), // 3 * 5
+		Mul, General1, General2, // 15 * -4
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(-60), rt.reg[General1])
+}
+
+func TestRuntime_Run_Div(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(100),
+		Mov, General2, Integer(-3),
+		Div, General1, Integer(5), // 100 / 5
+		Div, General1, General2, // 20 / -3, 0に向かって切り捨て
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(-6), rt.reg[General1])
+	// 0除算はエラー
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(1),
+		Mov, General2, Integer(0),
+		Div, General1, General2,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_Mod(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(17),
+		Mov, General2, Integer(3),
+		Mod, General1, Integer(10), // 17 % 10
+		Mod, General1, General2, // 7 % 3
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(1), rt.reg[General1])
+	// 0除算はエラー
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(1),
+		Mod, General1, Integer(0),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+	// 型が違うものはエラー
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Character('a'),
+		Mod, General1, Integer(2),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_And(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(0b1110),
+		Mov, General2, Integer(0b0111),
+		And, General1, General2, // 0b0110
+		And, General1, Integer(0b0100),
+		Mov, R1, True,
+		And, R1, True,
+		Mov, R2, True,
+		And, R2, False,
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(0b0100), rt.reg[General1])
+	assert.Equal(t, True, rt.reg[R1])
+	assert.Equal(t, False, rt.reg[R2])
+	// 型が違うものはエラー
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, True,
+		And, General1, Integer(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_Or(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(0b1000),
+		Or, General1, Integer(0b0001),
+		Mov, R1, False,
+		Or, R1, True,
+		Mov, R2, False,
+		Or, R2, False,
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(0b1001), rt.reg[General1])
+	assert.Equal(t, True, rt.reg[R1])
+	assert.Equal(t, False, rt.reg[R2])
+}
+
+func TestRuntime_Run_Xor(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(0b1100),
+		Xor, General1, Integer(0b1010),
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(0b0110), rt.reg[General1])
+	// boolのxorはサポートしない
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, True,
+		Xor, General1, False,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_Not(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(0),
+		Not, General1,
+		Mov, General2, True,
+		Not, General2,
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(-1), rt.reg[General1])
+	assert.Equal(t, False, rt.reg[General2])
+}
+
+func TestRuntime_Run_Shl(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(3),
+		Mov, General2, Integer(2),
+		Shl, General1, General2, // 3 << 2
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(12), rt.reg[General1])
+	// 負のシフトと64以上のシフトはエラー
+	for _, count := range []Integer{-1, 64, 1000} {
+		rt = NewRuntime(10, 10)
+		rt.Load(Program{
+			DefLabel(0),
+			Mov, General1, Integer(3),
+			Shl, General1, count,
+			Ret,
+		})
+		assert.Nil(t, rt.CollectLabels())
+		assert.NotNil(t, rt.Run(), count)
+	}
+	// 63までは使える
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(1),
+		Shl, General1, Integer(63),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(math.MinInt64), rt.reg[General1])
+}
+
+func TestRuntime_Run_Shr(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(12),
+		Shr, General1, Integer(2),
+		Mov, General2, Integer(-8),
+		Shr, General2, Integer(1), // 符号は保たれる
+		Ret,
+	})
+	err := rt.CollectLabels()
+	assert.Nil(t, err)
+	err = rt.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(3), rt.reg[General1])
+	assert.Equal(t, Integer(-4), rt.reg[General2])
+	// 64以上ずらすとエラー
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(-8),
+		Shr, General1, Integer(64),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+	assert.Equal(t, Integer(-8), rt.reg[General1])
+}
+
 func TestRuntime_Run_Push(t *testing.T) {
 	rt := NewRuntime(10, 10)
 	rt.program = Program{
@@ -190,11 +438,17 @@ func TestRuntime_Run_Push(t *testing.T) {
 	err := rt.Run()
 	assert.Nil(t, err)
 	assert.Equal(t, Integer(3), rt.stack[6]) // どう動いてるかよくわからん
-	assert.Equal(t, Integer(3), rt.pop())
+	v, err := rt.pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(3), v)
 	assert.Equal(t, Integer(2), rt.stack[7])
-	assert.Equal(t, Integer(2), rt.pop())
+	v, err = rt.pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(2), v)
 	assert.Equal(t, Integer(1), rt.stack[8])
-	assert.Equal(t, Integer(1), rt.pop())
+	v, err = rt.pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(1), v)
 }
 func TestRuntime_Run_Pop(t *testing.T) {
 	rt := NewRuntime(10, 10)
@@ -433,6 +687,546 @@ func TestRuntime_Run_Le(t *testing.T) {
 	assert.Equal(t, True, rt.reg[ZeroFlag]) // 大きさ比較なので型が違うものも許可してる.
 }
 
+func TestRuntime_Run_Gt(t *testing.T) {
+	tests := []struct {
+		name   string
+		lhs    Object
+		rhs    Object
+		expect Bool
+	}{
+		{"1 > 1", Integer(1), Integer(1), False},
+		{"2 > 1", Integer(2), Integer(1), True},
+		{"1 > 2", Integer(1), Integer(2), False},
+		{"char(2) > int(1)", Character(2), Integer(1), True}, // 大きさ比較なので型が違うものも許可してる.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(2, 1)
+			rt.Load(Program{
+				DefLabel(0),
+				Mov, General1, tt.lhs,
+				Gt, General1, tt.rhs,
+				Ret,
+			})
+			assert.Nil(t, rt.CollectLabels())
+			assert.Nil(t, rt.Run())
+			assert.Equal(t, tt.expect, rt.reg[ZeroFlag])
+		})
+	}
+}
+
+func TestRuntime_Run_Ge(t *testing.T) {
+	tests := []struct {
+		name   string
+		lhs    Object
+		rhs    Object
+		expect Bool
+	}{
+		{"1 >= 1", Integer(1), Integer(1), True},
+		{"2 >= 1", Integer(2), Integer(1), True},
+		{"1 >= 2", Integer(1), Integer(2), False},
+		{"char(1) >= int(2)", Character(1), Integer(2), False},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(2, 1)
+			rt.Load(Program{
+				DefLabel(0),
+				Mov, General1, tt.lhs,
+				Ge, General1, tt.rhs,
+				Ret,
+			})
+			assert.Nil(t, rt.CollectLabels())
+			assert.Nil(t, rt.Run())
+			assert.Equal(t, tt.expect, rt.reg[ZeroFlag])
+		})
+	}
+	// 空のレジスタとは比較できない
+	rt := NewRuntime(2, 1)
+	rt.Load(Program{
+		DefLabel(0),
+		Ge, General1, Integer(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_Compare_Operands(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     Opcode
+		lhs    Object
+		rhs    Object
+		expect Bool
+		err    bool
+	}{
+		{"empty == empty", Eq, R1, R2, True, false},
+		{"empty == null", Eq, R1, Null{}, False, false},
+		{"empty != 0", Ne, R1, Integer(0), True, false},
+		{"empty < 1", Lt, R1, Integer(1), False, true},
+		{"1 <= empty", Le, Integer(1), R1, False, true},
+		{"eq stack offset", Eq, *NewBPOffset(0), Integer(0), False, true},
+		{"lt stack offset", Lt, Integer(0), *NewBPOffset(0), False, true},
+		{"empty > 1", Gt, R1, Integer(1), False, true},
+		{"gt stack offset", Gt, *NewBPOffset(0), Integer(-1), False, true},
+		{"ge stack offset", Ge, Integer(0), *NewBPOffset(0), False, true},
+		// 比較して分岐する命令も同じ
+		{"empty beq empty", Beq, R1, R2, True, false},
+		{"empty blt 1", Blt, R1, Integer(1), False, true},
+		{"bgt stack offset", Bgt, *NewBPOffset(0), Integer(-1), False, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(2, 10)
+			prog := Program{DefLabel(0), tt.op, tt.lhs, tt.rhs}
+			if Operand(tt.op) == 3 { // 分岐したらzfにtrueを入れる
+				prog = append(prog, Label(1), Ret, DefLabel(1), Mov, ZeroFlag, True)
+			}
+			rt.Load(append(prog, Ret))
+			assert.Nil(t, rt.CollectLabels())
+			err := rt.Run()
+			if tt.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expect, rt.reg[ZeroFlag])
+		})
+	}
+}
+
+func TestRuntime_Run_Neg(t *testing.T) {
+	rt := NewRuntime(2, 1)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(5),
+		Neg, General1,
+		Mov, General2, Integer(-3),
+		Neg, General2,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(-5), rt.reg[General1])
+	assert.Equal(t, Integer(3), rt.reg[General2])
+	// boolは反転できない
+	rt = NewRuntime(2, 1)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, True,
+		Neg, General1,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
+
+func TestRuntime_Run_Float(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Float(1.5),
+		Mov, General2, Float(0.5),
+		Add, General1, Float(2), // 1.5 + 2
+		Mul, General1, General2, // 3.5 * 0.5
+		Sub, General1, Float(0.25), // 1.75 - 0.25
+		Div, General1, Float(4), // 1.5 / 4
+		Neg, General2,
+		Lt, General2, General1,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Float(0.375), rt.reg[General1])
+	assert.Equal(t, Float(-0.5), rt.reg[General2])
+	assert.Equal(t, True, rt.reg[ZeroFlag])
+
+	tests := []struct {
+		name string
+		prog Program
+	}{
+		{"mixed types", Program{DefLabel(0), Mov, General1, Float(1), Add, General1, Integer(1), Ret}},
+		{"division by zero", Program{DefLabel(0), Mov, General1, Float(1), Div, General1, Float(0), Ret}},
+		{"mod", Program{DefLabel(0), Mov, General1, Float(1), Mod, General1, Float(1), Ret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 10)
+			rt.Load(tt.prog)
+			assert.Nil(t, rt.CollectLabels())
+			assert.NotNil(t, rt.Run())
+		})
+	}
+}
+
+func TestRuntime_Run_Itof_Ftoi(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Integer(3),
+		Itof, General1,
+		Div, General1, Float(2), // 3.0 / 2
+		Mov, General2, General1,
+		Ftoi, General2, // 0に向かって切り捨て
+		Mov, R3, Float(-2.7),
+		Ftoi, R3,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Float(1.5), rt.reg[General1])
+	assert.Equal(t, Integer(1), rt.reg[General2])
+	assert.Equal(t, Integer(-2), rt.reg[R3])
+
+	// 整数で表せない値はエラー
+	for _, f := range []float64{math.Inf(1), math.Inf(-1), math.NaN(), 1e300, -1e300, math.MaxInt64} {
+		rt = NewRuntime(10, 10)
+		rt.Load(Program{
+			DefLabel(0),
+			Mov, General1, Float(f),
+			Ftoi, General1,
+			Ret,
+		})
+		assert.Nil(t, rt.CollectLabels())
+		assert.NotNil(t, rt.Run(), f)
+	}
+	// 範囲の端
+	rt = NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, General1, Float(math.MinInt64),
+		Ftoi, General1,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(math.MinInt64), rt.reg[General1])
+}
+
+func TestFloat_String(t *testing.T) {
+	tests := []struct {
+		f      Float
+		expect string
+	}{
+		{Float(1), "1.0"},
+		{Float(-0.5), "-0.5"},
+		{Float(1e21), "1e+21"},
+		{Float(math.Inf(-1)), "-Inf"},
+		{Float(math.NaN()), "NaN"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expect, tt.f.String())
+	}
+}
+
+func TestRuntime_Load_Constant(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	prog := Program{
+		DefLabel(0),
+		Mov, R1, String("hello"),
+		Push, String("world"),
+		Mov, R2, String("hello"), // 同じリテラルは1つにまとめる
+		Pop, R3,
+		Ret,
+	}
+	rt.Load(prog)
+	assert.Equal(t, ConstantPool{String("hello"), String("world")}, rt.consts)
+	assert.Equal(t, Constant(0), rt.program[4+3])
+	assert.Equal(t, Constant(1), rt.program[4+5])
+	assert.Equal(t, Constant(0), rt.program[4+8])
+	assert.Equal(t, String("hello"), prog[3]) // 渡したプログラムは書き換えない
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, String("hello"), rt.reg[R1])
+	assert.Equal(t, rt.reg[R1], rt.reg[R2])
+	assert.Equal(t, String("world"), rt.reg[R3])
+}
+
+func TestRuntime_Run_String(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, String("こんにちは"),
+		Concat, R1, String(", "),
+		Concat, R1, Character('w'),
+		Mov, R2, String("orld"),
+		Concat, R1, R2,
+		Len, R3, R1, // 文字数
+		Index, R4, R1, Integer(1),
+		Mov, R5, R1,
+		Substr, R5, Integer(3), Integer(7),
+		Eq, R5, String("ちは, "),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, String("こんにちは, world"), rt.reg[R1])
+	assert.Equal(t, Integer(12), rt.reg[R3])
+	assert.Equal(t, Character('ん'), rt.reg[R4])
+	assert.Equal(t, String("ちは, "), rt.reg[R5])
+	assert.Equal(t, True, rt.reg[ZeroFlag])
+
+	tests := []struct {
+		name string
+		prog Program
+	}{
+		{"concat to non-string", Program{DefLabel(0), Mov, R1, Integer(1), Concat, R1, String("a"), Ret}},
+		{"concat integer", Program{DefLabel(0), Mov, R1, String("a"), Concat, R1, Integer(1), Ret}},
+		{"len of non-string", Program{DefLabel(0), Len, R1, Integer(1), Ret}},
+		{"index out of range", Program{DefLabel(0), Index, R1, String("abc"), Integer(3), Ret}},
+		{"negative index", Program{DefLabel(0), Index, R1, String("abc"), Integer(-1), Ret}},
+		{"substr out of range", Program{DefLabel(0), Mov, R1, String("abc"), Substr, R1, Integer(2), Integer(4), Ret}},
+		{"substr reversed", Program{DefLabel(0), Mov, R1, String("abc"), Substr, R1, Integer(2), Integer(1), Ret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 10)
+			rt.Load(tt.prog)
+			assert.Nil(t, rt.CollectLabels())
+			assert.NotNil(t, rt.Run())
+		})
+	}
+}
+
+// 文字列どうしの計算は文字数の計算にせずエラーにする
+func TestRuntime_Run_String_Arithmetic(t *testing.T) {
+	for _, op := range []Opcode{Add, Sub, Mul, Div, Mod, And, Or, Xor, Shl, Shr} {
+		t.Run(op.String(), func(t *testing.T) {
+			rt := NewRuntime(10, 10)
+			rt.Load(Program{
+				DefLabel(0),
+				Mov, R1, String("ab"),
+				Mov, R2, String("xyz"),
+				op, R1, R2,
+				Ret,
+			})
+			assert.Nil(t, rt.CollectLabels())
+			err := rt.Run()
+			var rerr *RuntimeError
+			if assert.True(t, errors.As(err, &rerr), "%v", err) {
+				assert.Equal(t, BadOperand, rerr.Kind)
+				assert.Equal(t, 11, rerr.Pc)
+				assert.ErrorContains(t, err, "use Concat")
+			}
+			assert.Equal(t, String("ab"), rt.reg[R1])
+		})
+	}
+}
+
+func TestRuntime_Run_Branch(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     Opcode
+		lhs    Object
+		rhs    Object
+		branch bool
+	}{
+		{"beq taken", Beq, Integer(1), Integer(1), true},
+		{"beq type mismatch", Beq, Character(1), Integer(1), false},
+		{"bne taken", Bne, Integer(1), Integer(2), true},
+		{"bne not taken", Bne, True, True, false},
+		{"blt taken", Blt, Integer(1), Integer(2), true},
+		{"blt not taken", Blt, Integer(2), Integer(2), false},
+		{"ble taken", Ble, Integer(2), Integer(2), true},
+		{"ble not taken", Ble, Integer(3), Integer(2), false},
+		{"bgt taken", Bgt, Integer(3), Integer(2), true},
+		{"bgt not taken", Bgt, Integer(2), Integer(2), false},
+		{"bge taken", Bge, Integer(2), Integer(2), true},
+		{"bge not taken", Bge, Integer(1), Integer(2), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 1)
+			rt.Load(Program{
+				DefLabel(0),
+				Mov, General1, tt.lhs,
+				Mov, General2, Integer(0),
+				tt.op, General1, tt.rhs, Label(1), // 直接比較して分岐
+				Add, General2, Integer(1), // 分岐しなかった場合のみ
+				DefLabel(1),
+				Add, General2, Integer(2),
+				Ret,
+			})
+			assert.Nil(t, rt.CollectLabels())
+			assert.Nil(t, rt.Run())
+			if tt.branch {
+				assert.Equal(t, Integer(2), rt.reg[General2])
+			} else {
+				assert.Equal(t, Integer(3), rt.reg[General2])
+			}
+			assert.Nil(t, rt.reg[ZeroFlag]) // zfは使わない
+		})
+	}
+}
+
+func TestRuntime_Run_Load_Store(t *testing.T) {
+	rt := NewRuntime(10, 4)
+	rt.Load(Program{
+		DefLabel(0),
+		// 絶対位置
+		Store, MemoryOffset(0), Integer(42),
+		Load, General1, MemoryOffset(0),
+		// レジスタ間接
+		Mov, R1, Integer(1),
+		Mov, R2, Character('x'),
+		Store, MemoryRelativeOffset{R1, 0}, R2, // mem[1] = 'x'
+		Store, MemoryRelativeOffset{R1, 2}, True, // mem[3] = true
+		Load, General2, MemoryRelativeOffset{R1, 0},
+		Mov, R1, Integer(4),
+		Load, R3, MemoryRelativeOffset{R1, -1},
+		// 何も書き込まれていない場所はnull
+		Load, R4, MemoryOffset(2),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Integer(42), rt.reg[General1])
+	assert.Equal(t, Character('x'), rt.reg[General2])
+	assert.Equal(t, True, rt.reg[R3])
+	assert.Equal(t, Null{}, rt.reg[R4])
+	assert.Equal(t, Memory{Integer(42), Character('x'), nil, True}, rt.mem)
+
+	// 範囲外はpanicせずにエラーになる
+	tests := []struct {
+		name string
+		code Program
+	}{
+		{"load over", Program{Load, R1, MemoryOffset(4)}},
+		{"load negative", Program{Mov, R2, Integer(0), Load, R1, MemoryRelativeOffset{R2, -1}}},
+		{"store over", Program{Store, MemoryOffset(10), Integer(1)}},
+		{"store empty base", Program{Store, MemoryRelativeOffset{R5, 0}, Integer(1)}},
+		{"store to register", Program{Store, R1, Integer(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 4)
+			rt.Load(append(append(Program{DefLabel(0)}, tt.code...), Ret))
+			assert.Nil(t, rt.CollectLabels())
+			assert.NotNil(t, rt.Run())
+		})
+	}
+}
+
+func TestMemory_Get(t *testing.T) {
+	mem := *NewMemory(2)
+	assert.Nil(t, mem.Set(MemoryOffset(1), Integer(3)))
+	v, err := mem.Get(MemoryOffset(1))
+	assert.Nil(t, err)
+	assert.Equal(t, Integer(3), v)
+	_, err = mem.Get(MemoryOffset(2))
+	assert.NotNil(t, err)
+	_, err = mem.Get(MemoryOffset(-1))
+	assert.NotNil(t, err)
+}
+
+func TestRuntime_Run_Syscall_Alloc_Free(t *testing.T) {
+	rt := NewRuntime(10, 8)
+	rt.Load(Program{
+		DefLabel(0),
+		// 3つ分の配列を作って1,2,3を入れる
+		Syscall, Alloc, R1, Integer(3),
+		Store, MemoryRelativeOffset{R1, 0}, Integer(1),
+		Store, MemoryRelativeOffset{R1, 1}, Integer(2),
+		Mov, R2, R1,
+		Add, R2, Integer(2), // 参照をずらす
+		Store, MemoryRelativeOffset{R2, 0}, Integer(3),
+		// 大きさはレジスタでも渡せる
+		Mov, R3, Integer(4),
+		Syscall, Alloc, R4, R3,
+		Store, MemoryRelativeOffset{R4, 3}, Character('z'),
+		Syscall, Free, R1, Null{},
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, List(0), rt.reg[R1])
+	assert.Equal(t, List(2), rt.reg[R2])
+	assert.Equal(t, List(3), rt.reg[R4])
+	// 解放した領域は消える
+	assert.Equal(t, Memory{nil, nil, nil, nil, nil, nil, Character('z'), nil}, rt.mem)
+	assert.Equal(t, HeapStats{
+		Size: 8, Used: 4, Free: 4, Blocks: 1, FreeBlocks: 2, LargestFree: 3, Fragmentation: 0.25,
+	}, rt.HeapStats())
+
+	tests := []struct {
+		name string
+		code Program
+	}{
+		{"out of memory", Program{Syscall, Alloc, R1, Integer(9)}},
+		{"invalid size", Program{Syscall, Alloc, R1, Character('a')}},
+		{"alloc to stack", Program{Syscall, Alloc, StackRelativeOffset{BasePointer, 0}, Integer(1)}},
+		{"free integer", Program{Mov, R1, Integer(0), Syscall, Free, R1, Null{}}},
+		{"double free", Program{Syscall, Alloc, R1, Integer(1), Syscall, Free, R1, Null{}, Syscall, Free, R1, Null{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 8)
+			rt.Load(append(append(Program{DefLabel(0)}, tt.code...), Ret))
+			assert.Nil(t, rt.CollectLabels())
+			assert.NotNil(t, rt.Run())
+		})
+	}
+}
+
+// ヒープは大域変数の後ろから確保する
+func TestRuntime_Run_Syscall_Alloc_Globals(t *testing.T) {
+	rt := NewRuntime(10, 8, WithHeapBase(1))
+	rt.Load(Program{
+		DefLabel(0),
+		Store, MemoryOffset(0), Integer(99),
+		Syscall, Alloc, R1, Integer(1),
+		Store, MemoryRelativeOffset{R1, 0}, Integer(5),
+		Load, R2, MemoryOffset(0),
+		Syscall, GarbageCollect, Null{}, Null{},
+		Load, R3, MemoryOffset(0),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, List(1), rt.reg[R1])
+	assert.Equal(t, Integer(99), rt.reg[R2])
+	assert.Equal(t, Integer(99), rt.reg[R3])
+	assert.Equal(t, 7, rt.HeapStats().Size)
+	// 指定しなければメモリ全体がヒープ
+	rt = NewRuntime(10, 8)
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Alloc, R1, Integer(1),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, List(0), rt.reg[R1])
+	assert.Equal(t, 8, rt.HeapStats().Size)
+
+	// レジスタを通して指す大域変数も残る
+	rt = NewRuntime(10, 8, WithHeapBase(4))
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Integer(0),
+		Store, MemoryRelativeOffset{R1, 3}, Integer(99),
+		Syscall, Alloc, R2, Integer(4),
+		Load, R3, MemoryRelativeOffset{R1, 3},
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, List(4), rt.reg[R2])
+	assert.Equal(t, Integer(99), rt.reg[R3])
+	// ヒープの外は解放できない
+	rt = NewRuntime(10, 8, WithHeapBase(4))
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Alloc, R1, Integer(1),
+		Sub, R1, Integer(4), // mem[0]を指す
+		Syscall, Free, R1, Null{},
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.EqualError(t, rt.Run(), "system call failed at pc=12 (Syscall): heap: free of unallocated address: 0")
+}
+
 func TestRuntime_Run_Jmp(t *testing.T) {
 	rt := NewRuntime(2, 1)
 	rt.Load(Program{
@@ -526,11 +1320,8 @@ func TestRuntime_Run_Jne(t *testing.T) {
 }
 
 func TestRuntime_Run_Syscall_Write(t *testing.T) {
-	tmpStdout := os.Stdout // 標準出力を元に戻せるように保存
-	r, w, _ := os.Pipe()
-	os.Stdout = w // 標準出力の書き込み先を変更
-
-	rt := NewRuntime(2, 1)
+	var buf bytes.Buffer
+	rt := NewRuntime(2, 1, WithStdout(&buf))
 	rt.Load(Program{
 		DefLabel(0),
 		Syscall, Write, StdOut, Character('h'),
@@ -545,26 +1336,107 @@ func TestRuntime_Run_Syscall_Write(t *testing.T) {
 		Syscall, Write, StdOut, Character('l'),
 		Syscall, Write, StdOut, Character('d'),
 		Syscall, Write, StdOut, Character('!'),
+		Syscall, Write, StdOut, Float(2), // 小数は常に小数点付き
 		Ret,
 	})
 	assert.Nil(t, rt.CollectLabels())
 	assert.Nil(t, rt.Run())
 
-	_ = w.Close()
+	s := buf.String()
+	assert.Equal(t, "hello,world!2.0", s)
+}
+
+func TestRuntime_Run_Syscall_Write_String(t *testing.T) {
 	var buf bytes.Buffer
-	_, _ = buf.ReadFrom(r)
-	s := strings.TrimRight(buf.String(), "") // バッファーから文字列へ変換
-	os.Stdout = tmpStdout
-	assert.Equal(t, "hello,world!", s)
+	rt := NewRuntime(2, 1, WithStdout(&buf))
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Write, StdOut, String("hello, world!\n"),
+		Mov, R1, String("100%"), // 書式として扱わない
+		Syscall, Write, StdOut, R1,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+
+	assert.Equal(t, "hello, world!\n100%", buf.String())
 }
 
-func TestRuntime_Run_FizzBuzz(t *testing.T) {
-	tmpStdout := os.Stdout // 標準出力を元に戻せるように保存
-	r, w, _ := os.Pipe()
-	os.Stdout = w // 標準出力の書き込み先を変更
+func TestRuntime_Run_Syscall_Write_Stderr(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	rt := NewRuntime(2, 1, WithStdout(&stdout), WithStderr(&stderr))
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Write, StdOut, Character('o'),
+		Syscall, Write, StdErr, Character('e'),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, "o", stdout.String())
+	assert.Equal(t, "e", stderr.String())
+
+	// 標準入力には書き込めない
+	rt = NewRuntime(2, 1)
+	rt.Load(Program{
+		DefLabel(0),
+		Syscall, Write, StdIn, Character('i'),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+}
 
-	rt := NewRuntime(1000, 10)
+func TestRuntime_Run_Syscall_Read(t *testing.T) {
+	rt := NewRuntime(2, 1, WithStdin(strings.NewReader("あi\r\n 42 -7\nrest")))
 	rt.Load(Program{
+		DefLabel(0),
+		Syscall, ReadChar, StdIn, R1,
+		Syscall, ReadLine, StdIn, R2, // 行の残り
+		Syscall, ReadInt, StdIn, R3,
+		Syscall, ReadInt, StdIn, R4,
+		Syscall, ReadLine, StdIn, R5, // 数値の後ろの改行まで
+		Syscall, ReadLine, StdIn, R6, // 改行なしで終わる行
+		Syscall, ReadLine, StdIn, R7,
+		Syscall, ReadChar, StdIn, R8,
+		Syscall, ReadInt, StdIn, R9,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, Character('あ'), rt.reg[R1])
+	assert.Equal(t, String("i"), rt.reg[R2])
+	assert.Equal(t, Integer(42), rt.reg[R3])
+	assert.Equal(t, Integer(-7), rt.reg[R4])
+	assert.Equal(t, String(""), rt.reg[R5])
+	assert.Equal(t, String("rest"), rt.reg[R6])
+	// 入力が終わったらNull
+	assert.Equal(t, Null{}, rt.reg[R7])
+	assert.Equal(t, Null{}, rt.reg[R8])
+	assert.Equal(t, Null{}, rt.reg[R9])
+
+	tests := []struct {
+		name string
+		in   string
+		prog Program
+	}{
+		{"not a number", "abc", Program{DefLabel(0), Syscall, ReadInt, StdIn, R1, Ret}},
+		{"read from stdout", "a", Program{DefLabel(0), Syscall, ReadChar, StdOut, R1, Ret}},
+		{"dest is not register", "a", Program{DefLabel(0), Syscall, ReadChar, StdIn, Null{}, Ret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(2, 1, WithStdin(strings.NewReader(tt.in)))
+			rt.Load(tt.prog)
+			assert.Nil(t, rt.CollectLabels())
+			assert.NotNil(t, rt.Run())
+		})
+	}
+}
+
+// fizzBuzzProgram 1から100までのFizzBuzzを出力する
+func fizzBuzzProgram() Program {
+	return Program{
 		//fn check_x(n int, x int) bool {
 		//	n = n - x
 		//	if n == 0 {
@@ -927,15 +1799,17 @@ func TestRuntime_Run_FizzBuzz(t *testing.T) {
 		Mov, StackPointer, BasePointer,
 		Pop, BasePointer,
 		Ret,
-	})
+	}
+}
+
+func TestRuntime_Run_FizzBuzz(t *testing.T) {
+	var buf bytes.Buffer
+	rt := NewRuntime(1000, 10, WithStdout(&buf))
+	rt.Load(fizzBuzzProgram())
 	assert.Nil(t, rt.CollectLabels())
 	assert.Nil(t, rt.Run())
 
-	_ = w.Close()
-	var buf bytes.Buffer
-	_, _ = buf.ReadFrom(r)
-	s := strings.TrimRight(buf.String(), "") // バッファーから文字列へ変換
-	os.Stdout = tmpStdout
+	s := buf.String()
 
 	fizzbuzz := `1 
 2 
@@ -1042,9 +1916,9 @@ func TestRuntime_Run_FizzBuzz(t *testing.T) {
 	assert.Equal(t, Integer(0), rt.reg[ACM1])
 }
 
-func TestRuntime_Run_Fibonacci(t *testing.T) {
-	rt := NewRuntime(100, 10)
-	rt.Load(Program{
+// fibonacciProgram 再帰呼び出しでfib(n-1)+nを計算してR10に入れる, 1からnの和になる
+func fibonacciProgram(n int) Program {
+	return Program{
 		// func fib(n int) int {
 		//   if n < 2 {
 		//     return n
@@ -1204,7 +2078,7 @@ func TestRuntime_Run_Fibonacci(t *testing.T) {
 		Pop, R1,
 		Sub, StackPointer, R1,
 		//
-		Push, Integer(10),
+		Push, Integer(n),
 		//
 		Call, Label(1),
 		Push, Integer(1),
@@ -1217,7 +2091,12 @@ func TestRuntime_Run_Fibonacci(t *testing.T) {
 		Mov, StackPointer, BasePointer,
 		Pop, BasePointer,
 		Ret,
-	})
+	}
+}
+
+func TestRuntime_Run_Fibonacci(t *testing.T) {
+	rt := NewRuntime(100, 10)
+	rt.Load(fibonacciProgram(10))
 	assert.Nil(t, rt.CollectLabels())
 	assert.Nil(t, rt.Run())
 	assert.Equal(t, Integer(55), rt.reg[R10])
@@ -0,0 +1,594 @@
+package runtime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+)
+
+// スナップショットの形式
+//
+//	magic     "BRBS"
+//	version   uint16 (big endian)
+//	program   uvarint(byte数) + バイトコード
+//	id        sha256(バイトコード)の32byte
+//	symbols   uvarint(個数) + (varint(ラベル) varint(位置))*
+//	registers uvarint(個数) + slot*
+//	stack     uvarint(個数) + slot*
+//	memory    uvarint(個数) + slot*
+//	heap      1byte(ヒープを使ったか) + varint(ヒープの先頭) + uvarint(個数) + (varint(先頭) varint(大きさ))*
+//	started   1byte
+//	scheduler 1byte(Spawnしたか), Spawnしていれば以下が続く
+//	          uvarint(個数) + (varint(番号) varint(ラベル) varint(待っている番号) registers stack)*
+//	          uvarint(個数) + (varint(番号) slot)*  終わったスレッドの戻り値
+//	          varint(次の番号) varint(残りの命令数) 1byte(yield)
+//	rand      uvarint(byte数) + WithSchedulerSeedの乱数の状態, なければ0
+//
+// slotはバイトコードと同じobjectで, 何も入っていなければtagInvalidの1byteだけを置く.
+// 実行中のスレッドのregistersとstackは先頭のものを使うので, schedulerの先頭には個数0を置く.
+
+var snapshotMagic = []byte("BRBS")
+
+// SnapshotVersion 形式を変えるたびに上げる, ほかの版のスナップショットは読まない
+//
+//	1 最初の形式
+//	2 schedulerとrand
+const SnapshotVersion uint16 = 2
+
+var ErrProgramMismatch = errors.New("snapshot: program mismatch")
+
+// Snapshot 実行中の状態の写し
+type Snapshot struct {
+	Program   Program           // 起動用のコードを含む, 文字列は定数プールに移す前のまま
+	ID        [sha256.Size]byte // Programのバイトコードのsha256
+	Symbols   SymbolTable
+	Registers []Object
+	Stack     []Object
+	Memory    Memory
+	Heap      map[int]int        // 確保済み領域の先頭: 大きさ, ヒープを使っていなければnil
+	HeapBase  int                // WithHeapBaseで決めたヒープの先頭, これより前は大域変数
+	Started   bool               // StepやRunで実行を始めていたか
+	Scheduler *SnapshotScheduler // Spawnしていなければnil
+	Rand      []byte             // WithSchedulerSeedの乱数の状態, 指定していなければnil
+}
+
+// SnapshotScheduler スレッドの切り替えの状態
+type SnapshotScheduler struct {
+	Threads []SnapshotThread // 実行する順, 先頭が実行中
+	Done    map[int]Object   // 終わったスレッドの戻り値(r10)
+	NextID  int              // 次にSpawnするスレッドの番号
+	Left    int              // 実行中のスレッドが切り替わるまでの命令の数
+	Yield   bool             // 実行中のスレッドがYieldした
+}
+
+// SnapshotThread スレッド1つ分
+// 実行中のスレッドのRegistersとStackはnilで, SnapshotのRegistersとStackを使う
+type SnapshotThread struct {
+	ID        int
+	Label     Label // 実行を始めたラベル
+	Join      int   // Joinで待っているスレッド, 待っていなければ-1
+	Registers []Object
+	Stack     []Object
+}
+
+// Snapshot 今の状態を写し取る, RestoreすればContinueで続きから実行できる
+// Spawnしていれば, 実行中でないスレッドとスケジューラの状態も写す
+func (r *Runtime) Snapshot() (*Snapshot, error) {
+	prog, err := r.loadedProgram()
+	if err != nil {
+		return nil, err
+	}
+	id, err := programID(prog)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	s := &Snapshot{
+		Program:   prog,
+		ID:        id,
+		Symbols:   SymbolTable{},
+		Registers: append([]Object{}, r.reg...),
+		Stack:     append([]Object{}, r.stack...),
+		Memory:    append(Memory{}, r.mem...),
+		HeapBase:  r.heapBase,
+		Started:   r.started,
+	}
+	for label, offset := range r.sym {
+		s.Symbols[label] = offset
+	}
+	if r.heap != nil {
+		s.Heap = make(map[int]int, len(r.heap.used))
+		for addr, size := range r.heap.used {
+			s.Heap[addr] = size
+		}
+	}
+	if r.sched != nil {
+		s.Scheduler = &SnapshotScheduler{
+			Done:   make(map[int]Object, len(r.sched.done)),
+			NextID: r.sched.nextID,
+			Left:   r.sched.left,
+			Yield:  r.sched.yield,
+		}
+		for i, t := range r.sched.queue {
+			st := SnapshotThread{ID: t.id, Label: t.label, Join: t.join}
+			if i > 0 {
+				st.Registers = append([]Object{}, t.reg...)
+				st.Stack = append([]Object{}, t.stack...)
+			}
+			s.Scheduler.Threads = append(s.Scheduler.Threads, st)
+		}
+		for id, v := range r.sched.done {
+			s.Scheduler.Done[id] = v
+		}
+	}
+	if r.pcg != nil {
+		if s.Rand, err = r.pcg.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Restore スナップショットの状態に戻す
+// 別のプログラムを読み込んでいればErrProgramMismatchを返す, 何も読み込んでいなければスナップショットのものを使う
+// ヒープの先頭はスナップショットのものを使い, 入出力やfuel, ブレークポイントなどNewRuntimeやSet...で決めたものはそのまま
+func (r *Runtime) Restore(s *Snapshot) error {
+	id, err := programID(s.Program)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if id != s.ID {
+		return fmt.Errorf("%w: program does not match its id", ErrProgramMismatch)
+	}
+	if r.program != nil {
+		prog, err := r.loadedProgram()
+		if err != nil {
+			return err
+		}
+		if current, err := programID(prog); err != nil || current != s.ID {
+			return fmt.Errorf("%w: loaded program differs", ErrProgramMismatch)
+		}
+	}
+	if len(s.Registers) != int(_reg_end) {
+		return fmt.Errorf("snapshot: want %d registers, but got %d", _reg_end, len(s.Registers))
+	}
+	for _, reg := range []Register{BasePointer, StackPointer} {
+		if _, ok := s.Registers[reg].(Integer); !ok {
+			return fmt.Errorf("snapshot: %v must be an integer, but got: %v", reg, s.Registers[reg])
+		}
+	}
+	if s.HeapBase < 0 {
+		return fmt.Errorf("snapshot: invalid heap base: %d", s.HeapBase)
+	}
+	if err := s.Scheduler.validate(); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	var pcg *rand.PCG
+	if s.Rand != nil {
+		pcg = &rand.PCG{}
+		if err := pcg.UnmarshalBinary(s.Rand); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	var heap *Heap
+	if s.Heap != nil {
+		base := min(s.HeapBase, len(s.Memory))
+		if heap, err = restoreHeap(base, len(s.Memory)-base, s.Heap); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+
+	r.program, r.consts = internConstants(append(Program{}, s.Program...))
+	r.sym = SymbolTable{}
+	for label, offset := range s.Symbols {
+		r.sym[label] = offset
+	}
+	r.reg = append([]Object{}, s.Registers...)
+	r.stack = append([]Object{}, s.Stack...)
+	r.mem = append(Memory{}, s.Memory...)
+	r.heap = heap
+	r.heapBase = s.HeapBase
+	r.sched = s.Scheduler.restore(r.reg, r.stack)
+	if pcg != nil {
+		r.pcg = pcg
+	}
+	r.started = s.Started
+	r.executed = 0
+	if r.trace != nil { // スタックの大きさも中身も変わるので, 次のイベントで全部を差分として出す
+		r.trace.stack = nil
+	}
+	return nil
+}
+
+// validate スレッドの番号や待っている先が揃っているか, nilなら何もしない
+func (s *SnapshotScheduler) validate() error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Threads) == 0 {
+		return fmt.Errorf("no running thread")
+	}
+	seen := map[int]bool{}
+	for i, t := range s.Threads {
+		if t.ID < 0 || s.NextID <= t.ID || seen[t.ID] {
+			return fmt.Errorf("invalid thread id: %d", t.ID)
+		}
+		seen[t.ID] = true
+		if t.Join < -1 || s.NextID <= t.Join {
+			return fmt.Errorf("thread %d joins invalid thread: %d", t.ID, t.Join)
+		}
+		if i > 0 && (len(t.Registers) != int(_reg_end) || len(t.Stack) == 0) {
+			return fmt.Errorf("thread %d: want %d registers and a stack", t.ID, _reg_end)
+		}
+		if i > 0 {
+			for _, reg := range []Register{BasePointer, StackPointer} {
+				if _, ok := t.Registers[reg].(Integer); !ok {
+					return fmt.Errorf("thread %d: %v must be an integer, but got: %v", t.ID, reg, t.Registers[reg])
+				}
+			}
+		}
+	}
+	if !seen[0] {
+		return fmt.Errorf("main thread is missing")
+	}
+	for id := range s.Done {
+		if id <= 0 || s.NextID <= id || seen[id] {
+			return fmt.Errorf("invalid exited thread id: %d", id)
+		}
+	}
+	return nil
+}
+
+// restore スケジューラを作り直す, 先頭のスレッドはregとstackを使う
+func (s *SnapshotScheduler) restore(reg, stack []Object) *scheduler {
+	if s == nil {
+		return nil
+	}
+	sched := &scheduler{
+		done:   make(map[int]Object, len(s.Done)),
+		nextID: s.NextID,
+		left:   s.Left,
+		yield:  s.Yield,
+	}
+	for i, t := range s.Threads {
+		th := &thread{id: t.ID, label: t.Label, join: t.Join, reg: reg, stack: stack}
+		if i > 0 {
+			th.reg = append([]Object{}, t.Registers...)
+			th.stack = append([]Object{}, t.Stack...)
+		}
+		sched.queue = append(sched.queue, th)
+	}
+	for id, v := range s.Done {
+		sched.done[id] = v
+	}
+	return sched
+}
+
+// loadedProgram 読み込んだプログラムの写し, 定数プールの番号はLoadする前の文字列に戻す
+func (r *Runtime) loadedProgram() (Program, error) {
+	prog := make(Program, len(r.program))
+	for pc, obj := range r.program {
+		if c, ok := obj.(Constant); ok {
+			v, err := r.consts.Get(c)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot: pc(%d): %w", pc, err)
+			}
+			obj = v
+		}
+		prog[pc] = obj
+	}
+	return prog, nil
+}
+
+// programID プログラムのバイトコードのsha256
+func programID(prog Program) ([sha256.Size]byte, error) {
+	data, err := prog.MarshalBinary()
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	code, err := s.Program.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := append([]byte{}, snapshotMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, SnapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(code)))
+	buf = append(buf, code...)
+	buf = append(buf, s.ID[:]...)
+
+	labels := make([]Label, 0, len(s.Symbols))
+	for label := range s.Symbols {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+	buf = binary.AppendUvarint(buf, uint64(len(labels)))
+	for _, label := range labels {
+		buf = binary.AppendVarint(buf, int64(label))
+		buf = binary.AppendVarint(buf, int64(s.Symbols[label]))
+	}
+
+	for _, section := range []struct {
+		name  string
+		slots []Object
+	}{{"registers", s.Registers}, {"stack", s.Stack}, {"memory", s.Memory}} {
+		if buf, err = appendSlots(buf, section.name, section.slots); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Heap == nil {
+		buf = append(buf, 0)
+	} else {
+		buf = append(buf, 1)
+	}
+	buf = binary.AppendVarint(buf, int64(s.HeapBase))
+	addrs := make([]int, 0, len(s.Heap))
+	for addr := range s.Heap {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	buf = binary.AppendUvarint(buf, uint64(len(addrs)))
+	for _, addr := range addrs {
+		buf = binary.AppendVarint(buf, int64(addr))
+		buf = binary.AppendVarint(buf, int64(s.Heap[addr]))
+	}
+	buf = appendBool(buf, s.Started)
+
+	buf = appendBool(buf, s.Scheduler != nil)
+	if sched := s.Scheduler; sched != nil {
+		buf = binary.AppendUvarint(buf, uint64(len(sched.Threads)))
+		for _, t := range sched.Threads {
+			buf = binary.AppendVarint(buf, int64(t.ID))
+			buf = binary.AppendVarint(buf, int64(t.Label))
+			buf = binary.AppendVarint(buf, int64(t.Join))
+			name := fmt.Sprintf("thread %d ", t.ID)
+			if buf, err = appendSlots(buf, name+"registers", t.Registers); err != nil {
+				return nil, err
+			}
+			if buf, err = appendSlots(buf, name+"stack", t.Stack); err != nil {
+				return nil, err
+			}
+		}
+		ids := make([]int, 0, len(sched.Done))
+		for id := range sched.Done {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		buf = binary.AppendUvarint(buf, uint64(len(ids)))
+		for _, id := range ids {
+			buf = binary.AppendVarint(buf, int64(id))
+			if buf, err = appendSlots(buf, "done", []Object{sched.Done[id]}); err != nil {
+				return nil, err
+			}
+		}
+		buf = binary.AppendVarint(buf, int64(sched.NextID))
+		buf = binary.AppendVarint(buf, int64(sched.Left))
+		buf = appendBool(buf, sched.Yield)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(s.Rand)))
+	return append(buf, s.Rand...), nil
+}
+
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// appendSlots 個数と中身, 空の場所はtagInvalidにする
+func appendSlots(buf []byte, name string, slots []Object) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(slots)))
+	for i, obj := range slots {
+		if obj == nil {
+			buf = append(buf, byte(tagInvalid))
+			continue
+		}
+		var err error
+		if buf, err = appendObject(buf, obj); err != nil {
+			return nil, fmt.Errorf("snapshot: %s[%d]: %w", name, i, err)
+		}
+	}
+	return buf, nil
+}
+
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	magic, err := d.bytes(len(snapshotMagic))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, snapshotMagic) {
+		return ErrBadMagic
+	}
+	version, err := d.bytes(2)
+	if err != nil {
+		return err
+	}
+	v := binary.BigEndian.Uint16(version)
+	if v != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	var snap Snapshot
+	n, err := d.count()
+	if err != nil {
+		return err
+	}
+	code, err := d.bytes(n)
+	if err != nil {
+		return err
+	}
+	if err := snap.Program.UnmarshalBinary(code); err != nil {
+		return err
+	}
+	id, err := d.bytes(sha256.Size)
+	if err != nil {
+		return err
+	}
+	copy(snap.ID[:], id)
+
+	if n, err = d.count(); err != nil {
+		return err
+	}
+	snap.Symbols = SymbolTable{}
+	for range n {
+		label, err := d.int()
+		if err != nil {
+			return err
+		}
+		offset, err := d.int()
+		if err != nil {
+			return err
+		}
+		snap.Symbols[Label(label)] = ProgramAbsoluteOffset(offset)
+	}
+
+	for _, slots := range []*[]Object{&snap.Registers, &snap.Stack, (*[]Object)(&snap.Memory)} {
+		if *slots, err = d.slots(); err != nil {
+			return err
+		}
+	}
+
+	hasHeap, err := d.bytes(1)
+	if err != nil {
+		return err
+	}
+	if snap.HeapBase, err = d.int(); err != nil {
+		return err
+	}
+	if n, err = d.count(); err != nil {
+		return err
+	}
+	if hasHeap[0] == 1 {
+		snap.Heap = make(map[int]int, n)
+	}
+	for range n {
+		addr, err := d.int()
+		if err != nil {
+			return err
+		}
+		size, err := d.int()
+		if err != nil {
+			return err
+		}
+		if snap.Heap == nil {
+			return fmt.Errorf("%w: heap blocks without heap", ErrCorrupt)
+		}
+		snap.Heap[addr] = size
+	}
+	if snap.Started, err = d.bool(); err != nil {
+		return err
+	}
+	if snap.Scheduler, err = d.scheduler(); err != nil {
+		return err
+	}
+	if n, err = d.count(); err != nil {
+		return err
+	}
+	if n > 0 {
+		if snap.Rand, err = d.bytes(n); err != nil {
+			return err
+		}
+		snap.Rand = append([]byte{}, snap.Rand...)
+	}
+	if d.pos != len(d.buf) {
+		return fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(d.buf)-d.pos)
+	}
+	*s = snap
+	return nil
+}
+
+func (d *decoder) bool() (bool, error) {
+	b, err := d.bytes(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] == 1, nil
+}
+
+// slots appendSlotsで書いたもの
+func (d *decoder) slots() ([]Object, error) {
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]Object, 0, n)
+	for range n {
+		if d.pos < len(d.buf) && objectTag(d.buf[d.pos]) == tagInvalid { // 空
+			d.pos++
+			slots = append(slots, nil)
+			continue
+		}
+		obj, err := d.object()
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, obj)
+	}
+	return slots, nil
+}
+
+// scheduler Spawnしていなければnil
+func (d *decoder) scheduler() (*SnapshotScheduler, error) {
+	spawned, err := d.bool()
+	if err != nil || !spawned {
+		return nil, err
+	}
+	sched := &SnapshotScheduler{Done: map[int]Object{}}
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	for range n {
+		var t SnapshotThread
+		for _, v := range []*int{&t.ID, (*int)(&t.Label), &t.Join} {
+			if *v, err = d.int(); err != nil {
+				return nil, err
+			}
+		}
+		if t.Registers, err = d.slots(); err != nil {
+			return nil, err
+		}
+		if t.Stack, err = d.slots(); err != nil {
+			return nil, err
+		}
+		if len(t.Registers) == 0 { // 実行中のスレッド
+			t.Registers, t.Stack = nil, nil
+		}
+		sched.Threads = append(sched.Threads, t)
+	}
+	if n, err = d.count(); err != nil {
+		return nil, err
+	}
+	for range n {
+		id, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.slots()
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != 1 {
+			return nil, fmt.Errorf("%w: thread %d has %d return values", ErrCorrupt, id, len(v))
+		}
+		sched.Done[id] = v[0]
+	}
+	for _, v := range []*int{&sched.NextID, &sched.Left} {
+		if *v, err = d.int(); err != nil {
+			return nil, err
+		}
+	}
+	if sched.Yield, err = d.bool(); err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
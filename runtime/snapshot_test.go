@@ -0,0 +1,248 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 途中で止めてスナップショットを取り, 別のRuntimeで続きを実行しても同じ出力になる
+func TestRuntime_Snapshot_Restore(t *testing.T) {
+	var expect bytes.Buffer
+	whole := NewRuntime(1000, 10, WithStdout(&expect))
+	whole.Load(fizzBuzzProgram())
+	assert.Nil(t, whole.CollectLabels())
+	assert.Nil(t, whole.Run())
+
+	for _, fuel := range []int{1, 500, 2000} {
+		var before, after bytes.Buffer
+		rt := NewRuntime(1000, 10, WithStdout(&before), WithFuel(fuel))
+		rt.Load(fizzBuzzProgram())
+		assert.Nil(t, rt.CollectLabels())
+		var halt *HaltError
+		assert.True(t, errors.As(rt.Run(), &halt))
+
+		snap, err := rt.Snapshot()
+		assert.Nil(t, err)
+		data, err := snap.MarshalBinary()
+		assert.Nil(t, err)
+
+		var decoded Snapshot
+		assert.Nil(t, decoded.UnmarshalBinary(data))
+		assert.Equal(t, snap.ID, decoded.ID)
+		restored := NewRuntime(1000, 10, WithStdout(&after))
+		assert.Nil(t, restored.Restore(&decoded))
+		assert.Nil(t, restored.Continue())
+		assert.Equal(t, expect.String(), before.String()+after.String())
+		assert.Equal(t, whole.Status(), restored.Status())
+	}
+}
+
+// 止めては写すのを繰り返しても同じ結果になる
+func TestRuntime_Snapshot_Repeated(t *testing.T) {
+	var expect, out bytes.Buffer
+	whole := NewRuntime(1000, 10, WithStdout(&expect))
+	whole.Load(fizzBuzzProgram())
+	assert.Nil(t, whole.CollectLabels())
+	assert.Nil(t, whole.Run())
+
+	rt := NewRuntime(1000, 10, WithStdout(&out), WithFuel(300))
+	rt.Load(fizzBuzzProgram())
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	for i := 0; err != nil; i++ {
+		var halt *HaltError
+		if !assert.True(t, errors.As(err, &halt), "%v", err) || !assert.Less(t, i, 1000) {
+			return
+		}
+		snap, serr := rt.Snapshot()
+		assert.Nil(t, serr)
+		data, serr := snap.MarshalBinary()
+		assert.Nil(t, serr)
+		var decoded Snapshot
+		assert.Nil(t, decoded.UnmarshalBinary(data))
+		rt = NewRuntime(1000, 10, WithStdout(&out), WithFuel(300))
+		assert.Nil(t, rt.Restore(&decoded))
+		err = rt.Continue()
+	}
+	assert.Equal(t, expect.String(), out.String())
+	assert.Equal(t, whole.Status(), rt.Status())
+}
+
+func TestRuntime_Snapshot_Heap(t *testing.T) {
+	prog := Program{
+		DefLabel(0),
+		Syscall, Alloc, R1, Integer(3),
+		Store, MemoryRelativeOffset{R1, 0}, String("abc"),
+		Syscall, Alloc, R2, Integer(2),
+		Store, MemoryRelativeOffset{R2, 1}, Float(1.5),
+		Syscall, Free, R1, Null{},
+		Syscall, Alloc, R3, Integer(1),
+		Store, MemoryRelativeOffset{R3, 0}, Character('z'),
+		Ret,
+	}
+	whole := NewRuntime(10, 8)
+	whole.Load(prog)
+	assert.Nil(t, whole.CollectLabels())
+	assert.Nil(t, whole.Run())
+
+	rt := NewRuntime(10, 8, WithFuel(5))
+	rt.Load(prog)
+	assert.Nil(t, rt.CollectLabels())
+	var halt *HaltError
+	assert.True(t, errors.As(rt.Run(), &halt))
+	snap, err := rt.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]int{0: 3, 3: 2}, snap.Heap)
+	data, err := snap.MarshalBinary()
+	assert.Nil(t, err)
+
+	var decoded Snapshot
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, snap.Memory, decoded.Memory)
+	restored := NewRuntime(10, 8)
+	assert.Nil(t, restored.Restore(&decoded))
+	assert.Equal(t, rt.HeapStats(), restored.HeapStats())
+	assert.Nil(t, restored.Continue())
+	assert.Equal(t, whole.mem, restored.mem)
+	assert.Equal(t, whole.reg, restored.reg)
+	assert.Equal(t, whole.HeapStats(), restored.HeapStats())
+}
+
+func TestRuntime_Restore_Error(t *testing.T) {
+	rt := NewRuntime(1000, 10, WithFuel(10))
+	rt.Load(fizzBuzzProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+	snap, err := rt.Snapshot()
+	assert.Nil(t, err)
+
+	other := NewRuntime(1000, 10)
+	other.Load(fnProgram())
+	assert.True(t, errors.Is(other.Restore(snap), ErrProgramMismatch))
+
+	tampered := *snap
+	tampered.Program = append(Program{}, snap.Program...)
+	tampered.Program[len(tampered.Program)-1] = Ret
+	tampered.Program = append(tampered.Program, Ret)
+	assert.True(t, errors.Is(NewRuntime(1000, 10).Restore(&tampered), ErrProgramMismatch))
+
+	tampered = *snap
+	tampered.Registers = snap.Registers[:3]
+	assert.NotNil(t, NewRuntime(1000, 10).Restore(&tampered))
+
+	tampered = *snap
+	tampered.Scheduler = &SnapshotScheduler{Threads: []SnapshotThread{{ID: 1, Join: -1}}, NextID: 2}
+	assert.EqualError(t, NewRuntime(1000, 10).Restore(&tampered), "snapshot: main thread is missing")
+
+	tampered = *snap
+	tampered.Heap = map[int]int{0: 3, 2: 2}
+	assert.NotNil(t, NewRuntime(1000, 10).Restore(&tampered))
+
+	tampered = *snap
+	tampered.Registers = append([]Object{}, snap.Registers...)
+	tampered.Registers[StackPointer] = Null{}
+	assert.NotNil(t, NewRuntime(1000, 10).Restore(&tampered))
+}
+
+// ヒープの先頭はスナップショットから戻すので, 大域変数を新しく確保した領域で上書きしない
+func TestRuntime_Snapshot_HeapBase(t *testing.T) {
+	prog := Program{
+		DefLabel(0),
+		Store, MemoryRelativeOffset{R0, 0}, Integer(7),
+		Store, MemoryRelativeOffset{R0, 1}, Integer(8),
+		Syscall, Alloc, R1, Integer(1),
+		Syscall, Alloc, R2, Integer(2),
+		Store, MemoryRelativeOffset{R2, 0}, Integer(9),
+		Load, R3, MemoryRelativeOffset{R0, 0},
+		Ret,
+	}
+	rt := NewRuntime(20, 8, WithHeapBase(2), WithFuel(4))
+	rt.Load(prog)
+	assert.Nil(t, rt.CollectLabels())
+	rt.reg[R0] = Integer(0)
+	var halt *HaltError
+	assert.True(t, errors.As(rt.Run(), &halt))
+	snap, err := rt.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, snap.HeapBase)
+	data, err := snap.MarshalBinary()
+	assert.Nil(t, err)
+
+	var decoded Snapshot
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, snap, &decoded)
+	restored := NewRuntime(20, 8)
+	assert.Nil(t, restored.Restore(&decoded))
+	assert.Nil(t, restored.Continue())
+	assert.Equal(t, Integer(7), restored.Register(R3))
+	assert.Equal(t, Memory{Integer(7), Integer(8), nil, Integer(9), nil, nil, nil, nil}, restored.mem)
+	assert.Equal(t, List(3), restored.Register(R2))
+}
+
+// トレース中に大きさの違うスタックのスナップショットに戻しても続けられる
+func TestRuntime_Restore_Trace(t *testing.T) {
+	prog := Program{
+		DefLabel(0),
+		Push, Integer(1),
+		Push, Integer(2),
+		Pop, R1,
+		Pop, R2,
+		Ret,
+	}
+	big := NewRuntime(50, 10, WithFuel(3))
+	big.Load(prog)
+	assert.Nil(t, big.CollectLabels())
+	assert.NotNil(t, big.Run())
+	snap, err := big.Snapshot()
+	assert.Nil(t, err)
+
+	var events []TraceEvent
+	rt := NewRuntime(10, 10)
+	rt.SetTraceHook(func(ev TraceEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	assert.Nil(t, rt.Restore(snap))
+	assert.NotPanics(t, func() { assert.Nil(t, rt.Continue()) })
+	if assert.NotEmpty(t, events) {
+		assert.NotEmpty(t, events[0].Stack) // 戻したスタックを全部出す
+	}
+}
+
+func TestSnapshot_UnmarshalBinary_Error(t *testing.T) {
+	rt := NewRuntime(1000, 10, WithFuel(10))
+	rt.Load(fizzBuzzProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.NotNil(t, rt.Run())
+	snap, err := rt.Snapshot()
+	assert.Nil(t, err)
+	data, err := snap.MarshalBinary()
+	assert.Nil(t, err)
+
+	var s Snapshot
+	assert.True(t, errors.Is(s.UnmarshalBinary([]byte("BRBC\x00\x01")), ErrBadMagic))
+	assert.True(t, errors.Is(s.UnmarshalBinary([]byte("BRBS\x00\x09")), ErrUnsupportedVersion))
+	for _, n := range []int{0, 5, len(data) / 2, len(data) - 1} {
+		assert.True(t, errors.Is(s.UnmarshalBinary(data[:n]), ErrTruncated), "%d", n)
+	}
+	assert.True(t, errors.Is(s.UnmarshalBinary(append(data, 0)), ErrCorrupt))
+
+	// ほかの版は読まない
+	old := append([]byte{}, data...)
+	old[5]--
+	assert.True(t, errors.Is(s.UnmarshalBinary(old), ErrUnsupportedVersion))
+	assert.Nil(t, s.UnmarshalBinary(data))
+	assert.Equal(t, snap, &s)
+}
+
+func TestRuntime_Continue(t *testing.T) {
+	var out bytes.Buffer
+	rt := NewRuntime(10, 10, WithStdout(&out))
+	rt.Load(fnProgram())
+	assert.Nil(t, rt.CollectLabels())
+	// 始めていなければRunと同じ
+	assert.Nil(t, rt.Continue())
+	assert.EqualError(t, rt.Continue(), "program has already exited")
+}
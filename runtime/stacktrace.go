@@ -0,0 +1,161 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourcePos ソース上の位置
+type SourcePos struct {
+	File   string // 分からなければ空
+	Line   int
+	Column int
+}
+
+func (p SourcePos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// DebugInfo プログラムとソースの対応
+// PositionsのキーはLoadに渡したプログラムの中の位置(Loadが足す起動用のコードを含まない)
+type DebugInfo struct {
+	Names     map[Label]string  // ラベル番号: 関数名
+	Positions map[int]SourcePos // 命令の位置: ソースの位置
+}
+
+// WithDebugInfo スタックトレースに関数名とソースの位置を出す
+func WithDebugInfo(info *DebugInfo) Option {
+	return func(r *Runtime) {
+		r.debug = info
+	}
+}
+
+// StackFrame スタックトレースの1つ分
+type StackFrame struct {
+	Pc    int        // 実行中の命令, 呼び出し元ならcall命令
+	Label Label      // pcを含む関数
+	Name  string     // 関数名, 分からなければmainかl_3のような名前
+	Pos   *SourcePos // デバッグ情報がなければnil
+}
+
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s pc=%d", f.Name, f.Pc)
+}
+
+// StackTrace 呼び出しの連なり, 先頭が実行中の関数
+type StackTrace []StackFrame
+
+// String 1行に1つ, 位置が分かるものはfile:line:columnも付ける
+//
+//	at fib (fib.s:12:3) pc=10
+//	at fib (fib.s:20:3) pc=33
+//	at main (fib.s:40:3) pc=50
+func (t StackTrace) String() string {
+	var sb strings.Builder
+	for _, f := range t {
+		sb.WriteString("\tat ")
+		sb.WriteString(f.Name)
+		if f.Pos != nil {
+			fmt.Fprintf(&sb, " (%v)", *f.Pos)
+		}
+		fmt.Fprintf(&sb, " pc=%d\n", f.Pc)
+	}
+	return sb.String()
+}
+
+// StackTrace bpとスタック上の戻り先を辿って呼び出しの連なりを作る
+// 呼び出し元はcall命令の位置を指す, Loadが足した起動用のコードは含めない
+// 関数はそのフレームを作ったcallの飛び先で, 関数の中のラベルにはしない
+func (r *Runtime) StackTrace() StackTrace {
+	frames := r.Frames()
+	if ret, ok := r.prologueReturn(); ok { // 呼び出し元はまだbpで辿れない
+		frames = append([]Frame{frames[0], {Pc: ret, Bp: r.bp()}}, frames[1:]...)
+	}
+	var trace StackTrace
+	for i, f := range frames {
+		pc := f.Pc
+		if i > 0 { // 戻り先の直前にcallがある
+			pc -= 1 + Operand(Call)
+		}
+		if r.enclosingLabel(pc) == Label(-1) {
+			break
+		}
+		label, ok := Label(0), false
+		if i+1 < len(frames) {
+			label, ok = r.calledLabel(frames[i+1].Pc - 1 - Operand(Call))
+		}
+		if !ok {
+			label = r.enclosingLabel(pc)
+		}
+		frame := StackFrame{Pc: pc, Label: label, Name: r.funcName(label)}
+		if pos, ok := r.sourcePos(pc); ok {
+			frame.Pos = &pos
+		}
+		trace = append(trace, frame)
+	}
+	return trace
+}
+
+// calledLabel pcのcallが呼ぶラベル
+// 起動用のコードのcallから始まったSpawnのスレッドなら, そのスレッドを始めたラベル
+func (r *Runtime) calledLabel(pc int) (Label, bool) {
+	if pc < 0 || len(r.program) <= pc+Operand(Call) || r.program[pc] != Call {
+		return 0, false
+	}
+	label, ok := r.program[pc+1].(Label)
+	if ok && r.enclosingLabel(pc) == Label(-1) {
+		label = r.threadLabel()
+	}
+	return label, ok
+}
+
+// prologueReturn 関数の先頭で push bp, mov bp sp を終える前なら, スタックにある戻り先
+func (r *Runtime) prologueReturn() (int, bool) {
+	sp := r.sp()
+	for i, pushed := range []bool{false, true} { // push bpの前と後
+		at := sp + i
+		if at < 0 || len(r.stack)-1 <= at {
+			break
+		}
+		ret, ok := r.stack[at].(ProgramAbsoluteOffset)
+		if !ok {
+			continue
+		}
+		label, ok := r.calledLabel(ret.Value() - 1 - Operand(Call))
+		if !ok {
+			continue
+		}
+		offset, err := r.sym.Get(label)
+		if err != nil {
+			continue
+		}
+		entry := r.program.firstOpcode(offset.Value())
+		if !pushed && r.pc() == entry {
+			return ret.Value(), true
+		}
+		if pushed && 0 <= entry && entry+1 < len(r.program) && r.program[entry] == Push && r.program[entry+1] == BasePointer &&
+			r.stack[sp] == Integer(r.bp()) && r.pc() == r.program.firstOpcode(entry+1+Operand(Push)) {
+			return ret.Value(), true
+		}
+	}
+	return 0, false
+}
+
+func (r *Runtime) funcName(label Label) string {
+	var names map[Label]string
+	if r.debug != nil {
+		names = r.debug.Names
+	}
+	return LabelName(label, names)
+}
+
+func (r *Runtime) sourcePos(pc int) (SourcePos, bool) {
+	if r.debug == nil {
+		return SourcePos{}, false
+	}
+	pos, ok := r.debug.Positions[pc-len(startup)]
+	return pos, ok
+}
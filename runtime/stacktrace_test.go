@@ -0,0 +1,141 @@
+package runtime
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// recursiveProgram f(n)は1/nを計算してからf(n-1)を呼ぶので, f(0)で0除算になる
+func recursiveProgram() Program {
+	return Program{
+		DefLabel(0), // 4
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Push, Integer(2),
+		Call, Label(1), // 12
+		Ret,
+		DefLabel(1), // 15
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Mov, R1, *NewBPOffset(2),
+		Mov, R2, Integer(1),
+		Div, R2, R1, // 27
+		Sub, R1, Integer(1),
+		Push, R1,
+		Call, Label(1), // 35
+		Ret,
+	}
+}
+
+func TestRuntime_StackTrace(t *testing.T) {
+	rt := NewRuntime(100, 10)
+	rt.Load(recursiveProgram())
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	var rerr *RuntimeError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, DivisionByZero, rerr.Kind)
+	assert.Equal(t, StackTrace{
+		{Pc: 27, Label: 1, Name: "l_1"},
+		{Pc: 35, Label: 1, Name: "l_1"},
+		{Pc: 35, Label: 1, Name: "l_1"},
+		{Pc: 12, Label: 0, Name: "main"},
+	}, rerr.Trace)
+	assert.Equal(t, rerr.Trace, rt.StackTrace())
+	assert.Equal(t, "\tat l_1 pc=27\n\tat l_1 pc=35\n\tat l_1 pc=35\n\tat main pc=12\n", rerr.Trace.String())
+}
+
+func TestRuntime_StackTrace_DebugInfo(t *testing.T) {
+	rt := NewRuntime(100, 10, WithDebugInfo(&DebugInfo{
+		Names: map[Label]string{1: "f"},
+		// Loadに渡したプログラムの中の位置
+		Positions: map[int]SourcePos{
+			8:  {"f.s", 3, 2},
+			23: {"f.s", 10, 2},
+			31: {"f.s", 13, 2},
+		},
+	}))
+	rt.Load(recursiveProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.SetBreakpoint(27))
+	// f(2)のdivで止まる
+	assert.Nil(t, rt.RunUntilBreak())
+	trace := rt.StackTrace()
+	assert.Equal(t, "\tat f (f.s:10:2) pc=27\n\tat main (f.s:3:2) pc=12\n", trace.String())
+
+	rt.ClearBreakpoint(27)
+	err := rt.RunUntilBreak()
+	var rerr *RuntimeError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, &SourcePos{"f.s", 10, 2}, rerr.Trace[0].Pos)
+	assert.Equal(t, &SourcePos{"f.s", 13, 2}, rerr.Trace[1].Pos)
+	assert.Equal(t, "f", rerr.Trace[2].Name)
+	assert.Len(t, rerr.Trace, 4)
+}
+
+// 関数の中のラベルの後で失敗しても, callで入った関数として出す
+func TestRuntime_StackTrace_InnerLabel(t *testing.T) {
+	rt := NewRuntime(100, 10, WithDebugInfo(&DebugInfo{Names: map[Label]string{1: "f", 2: "zero"}}))
+	rt.Load(Program{
+		DefLabel(0), // 4
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Call, Label(1), // 10
+		Ret,
+		DefLabel(1), // 13
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Mov, R1, Integer(0),
+		Beq, R1, Integer(0), Label(2),
+		Ret,
+		DefLabel(2), // 27
+		Mov, R2, Integer(1),
+		Div, R2, R1, // 31
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	var rerr *RuntimeError
+	if assert.True(t, errors.As(err, &rerr), "%v", err) {
+		assert.Equal(t, DivisionByZero, rerr.Kind)
+		assert.Equal(t, StackTrace{
+			{Pc: 31, Label: 1, Name: "f"},
+			{Pc: 10, Label: 0, Name: "main"},
+		}, rerr.Trace)
+	}
+}
+
+// push bp, mov bp sp を終える前でも呼び出し元を出す
+func TestRuntime_StackTrace_Prologue(t *testing.T) {
+	rt := NewRuntime(100, 10)
+	rt.Load(recursiveProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.SetBreakpoint(16)) // push bp
+	assert.Nil(t, rt.RunUntilBreak())
+	expect := StackTrace{
+		{Pc: 16, Label: 1, Name: "l_1"},
+		{Pc: 12, Label: 0, Name: "main"},
+	}
+	assert.Equal(t, expect, rt.StackTrace())
+	assert.Nil(t, rt.Step()) // push bpの後
+	expect[0].Pc = 18
+	assert.Equal(t, expect, rt.StackTrace())
+	assert.Nil(t, rt.Step()) // mov bp spの後はbpで辿れる
+	expect[0].Pc = 21
+	assert.Equal(t, expect, rt.StackTrace())
+
+	// 呼んだ直後のpush bpで溢れる
+	rt = NewRuntime(5, 10)
+	rt.Load(recursiveProgram())
+	assert.Nil(t, rt.CollectLabels())
+	err := rt.Run()
+	var rerr *RuntimeError
+	if assert.True(t, errors.As(err, &rerr), "%v", err) {
+		assert.Equal(t, StackOverflow, rerr.Kind)
+		assert.Equal(t, StackTrace{
+			{Pc: 16, Label: 1, Name: "l_1"},
+			{Pc: 12, Label: 0, Name: "main"},
+		}, rerr.Trace)
+	}
+}
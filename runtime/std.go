@@ -23,3 +23,13 @@ const (
 	StdOut
 	StdErr
 )
+
+// LookupStandardIO 名前から標準入出力を探す
+func LookupStandardIO(name string) (StandardIO, bool) {
+	for _, s := range []StandardIO{StdIn, StdOut, StdErr} {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return 0, false
+}
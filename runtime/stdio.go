@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// reader 最初に読むときにos.Stdinを使うようにする
+func (r *Runtime) reader() *bufio.Reader {
+	if r.stdin == nil {
+		r.stdin = bufio.NewReader(os.Stdin)
+	}
+	return r.stdin
+}
+
+// writer 標準出力と標準エラー出力の書き込み先
+func (r *Runtime) writer(s StandardIO) (io.Writer, bool) {
+	switch s {
+	case StdOut:
+		if r.stdout == nil {
+			return os.Stdout, true
+		}
+		return r.stdout, true
+	case StdErr:
+		if r.stderr == nil {
+			return os.Stderr, true
+		}
+		return r.stderr, true
+	default:
+		return nil, false
+	}
+}
+
+// read StdInから1つ読む, 何も読めずに終わったらNullを返す
+func (r *Runtime) read(no SystemCall) (Object, error) {
+	in := r.reader()
+	switch no {
+	case ReadChar:
+		c, _, err := in.ReadRune()
+		if errors.Is(err, io.EOF) {
+			return Null{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Character(c), nil
+	case ReadLine: // 改行は含めない
+		line, err := in.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return Null{}, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		return String(line), nil
+	case ReadInt: // 空白を読み飛ばして次の整数を読む
+		var n int
+		_, err := fmt.Fscan(in, &n)
+		if errors.Is(err, io.EOF) {
+			return Null{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read int: %w", err)
+		}
+		return Integer(n), nil
+	default:
+		return nil, fmt.Errorf("unsupported read syscall: %v", no)
+	}
+}
@@ -0,0 +1,189 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Step 命令を1つ実行する, 始める前なら擬似的なプロセスの先頭から始める
+func (r *Runtime) Step() error {
+	if r.Exited() {
+		return fmt.Errorf("program has already exited")
+	}
+	if !r.started {
+		if err := r.start(); err != nil {
+			return err
+		}
+	}
+	return r.step()
+}
+
+// RunUntilBreak ブレークポイントの命令を実行する前か, 終了するまで実行する
+// 今いる命令がブレークポイントでも, 少なくとも1つは実行する
+func (r *Runtime) RunUntilBreak() error {
+	for {
+		if err := r.Step(); err != nil {
+			return err
+		}
+		if r.Exited() || r.HasBreakpoint(r.pc()) {
+			return nil
+		}
+	}
+}
+
+// Exited Exit命令を実行したか
+func (r *Runtime) Exited() bool {
+	return r.mustExit()
+}
+
+// SetBreakpoint pcの命令にブレークポイントを置く
+func (r *Runtime) SetBreakpoint(pc int) error {
+	if pc < 0 || len(r.program) <= pc {
+		return fmt.Errorf("breakpoint out of range: %d", pc)
+	}
+	if _, ok := r.program[pc].(Opcode); !ok {
+		return fmt.Errorf("breakpoint is not on an instruction: pc=%d, %v", pc, r.program[pc])
+	}
+	if r.breakpoints == nil {
+		r.breakpoints = map[int]bool{}
+	}
+	r.breakpoints[pc] = true
+	return nil
+}
+
+// SetBreakpointAtLabel ラベルの最初の命令にブレークポイントを置く, CollectLabelsの後に使う
+func (r *Runtime) SetBreakpointAtLabel(label Label) (int, error) {
+	pc, err := r.LabelPc(label)
+	if err != nil {
+		return 0, err
+	}
+	return pc, r.SetBreakpoint(pc)
+}
+
+// LabelPc ラベルの最初の命令の位置, CollectLabelsの後に使う
+func (r *Runtime) LabelPc(label Label) (int, error) {
+	offset, err := r.sym.Get(label)
+	if err != nil {
+		return 0, err
+	}
+	pc := offset.Value()
+	for pc < len(r.program) {
+		if _, ok := r.program[pc].(DefLabel); !ok {
+			break
+		}
+		pc++
+	}
+	return pc, nil
+}
+
+// ClearBreakpoint ブレークポイントを取り除く
+func (r *Runtime) ClearBreakpoint(pc int) {
+	delete(r.breakpoints, pc)
+}
+
+// HasBreakpoint pcにブレークポイントがあるか
+func (r *Runtime) HasBreakpoint(pc int) bool {
+	return r.breakpoints[pc]
+}
+
+// Breakpoints ブレークポイントのpcを小さい順に返す
+func (r *Runtime) Breakpoints() []int {
+	pcs := make([]int, 0, len(r.breakpoints))
+	for pc := range r.breakpoints {
+		pcs = append(pcs, pc)
+	}
+	sort.Ints(pcs)
+	return pcs
+}
+
+// Pc 次に実行する命令の位置
+func (r *Runtime) Pc() int {
+	return r.pc()
+}
+
+// Program Loadしたプログラム, 擬似的なプロセスのコードを含む
+func (r *Runtime) Program() Program {
+	return append(Program{}, r.program...)
+}
+
+// Registers レジスタの写し, Registerを添字に使う
+func (r *Runtime) Registers() []Object {
+	return append([]Object{}, r.reg...)
+}
+
+// Register レジスタ1つの値
+func (r *Runtime) Register(reg Register) Object {
+	return r.reg[reg]
+}
+
+// Stack スタックに積まれている値の写し, 先頭がスタックの一番上
+func (r *Runtime) Stack() []Object {
+	sp := max(r.sp(), 0)
+	if sp >= len(r.stack)-1 {
+		return []Object{}
+	}
+	return append([]Object{}, r.stack[sp:len(r.stack)-1]...)
+}
+
+// Frame 呼び出し1つ分の情報
+type Frame struct {
+	Pc int // 実行中か, 戻った後に実行する命令の位置
+	Bp int // このフレームのbp
+}
+
+// Frames bpを辿って呼び出しの連なりを返す, 先頭が実行中の関数
+// 関数の先頭で push bp, mov bp sp した後のフレームだけを辿れる
+// [bp]に呼び出し元のbp, [bp+1]に戻り先がある
+func (r *Runtime) Frames() []Frame {
+	frames := []Frame{{Pc: r.pc(), Bp: r.bp()}}
+	for bp := r.bp(); 0 <= bp && bp+1 < len(r.stack)-1; {
+		ret, ok := r.stack[bp+1].(ProgramAbsoluteOffset)
+		if !ok {
+			break
+		}
+		saved, ok := r.stack[bp].(Integer)
+		if !ok {
+			break
+		}
+		frames = append(frames, Frame{Pc: ret.Value(), Bp: saved.Value()})
+		// 呼び出し元のフレームは必ず上(大きいアドレス)にある
+		if saved.Value() <= bp {
+			break
+		}
+		bp = saved.Value()
+	}
+	return frames
+}
+
+// Constants Loadで作った定数プールの写し
+func (r *Runtime) Constants() ConstantPool {
+	return append(ConstantPool{}, r.consts...)
+}
+
+// Inspect オペランドが指している値を読む, 何も入っていなければnil
+// レジスタ, スタック, メモリ以外はそのまま返す
+func (r *Runtime) Inspect(obj Object) (Object, error) {
+	switch obj := obj.(type) {
+	case Register:
+		if obj < 0 || _reg_end <= obj {
+			return nil, fmt.Errorf("invalid register: %d", obj)
+		}
+		return r.reg[obj], nil
+	case *StackRelativeOffset:
+		return r.Inspect(*obj)
+	case StackRelativeOffset:
+		return r.stackAt(obj)
+	case *MemoryRelativeOffset:
+		return r.Inspect(*obj)
+	case MemoryOffset, MemoryRelativeOffset:
+		addr, err := r.memoryAddress(obj)
+		if err != nil {
+			return nil, err
+		}
+		return r.mem.Get(addr)
+	case Constant:
+		return r.consts.Get(obj)
+	default:
+		return obj, nil
+	}
+}
@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fnProgram mainからl_1を呼び出し, l_1でr1を2回増やす
+func fnProgram() Program {
+	return Program{
+		DefLabel(1),
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Add, R1, Integer(1),
+		Add, R1, Integer(1),
+		Mov, StackPointer, BasePointer,
+		Pop, BasePointer,
+		Ret,
+		DefLabel(0),
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Mov, R1, Integer(0),
+		Call, Label(1),
+		Mov, StackPointer, BasePointer,
+		Pop, BasePointer,
+		Ret,
+	}
+}
+
+func TestRuntime_Step(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Integer(1),
+		Add, R1, Integer(2),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	// startup: call main
+	assert.Nil(t, rt.Step())
+	assert.Equal(t, 5, rt.Pc()) // ラベル定義は読み飛ばす
+	assert.Equal(t, Mov, rt.Program()[rt.Pc()])
+	assert.Nil(t, rt.Step())
+	assert.Equal(t, Integer(1), rt.Register(R1))
+	assert.Nil(t, rt.Step())
+	assert.Equal(t, Integer(3), rt.Registers()[R1])
+	assert.Nil(t, rt.Step()) // ret
+	assert.False(t, rt.Exited())
+	assert.Nil(t, rt.Step()) // exit
+	assert.True(t, rt.Exited())
+	assert.NotNil(t, rt.Step())
+}
+
+func TestRuntime_RunUntilBreak(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(fnProgram())
+	assert.Nil(t, rt.CollectLabels())
+	fn, err := rt.SetBreakpointAtLabel(Label(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, fn)
+	assert.Nil(t, rt.SetBreakpoint(fn+1+2+2)) // 1つ目のadd
+	assert.Equal(t, []int{fn, fn + 5}, rt.Breakpoints())
+
+	assert.Nil(t, rt.RunUntilBreak())
+	assert.Equal(t, fn, rt.Pc())
+	assert.Equal(t, Integer(0), rt.Register(R1))
+	// 止まった場所から続けられる
+	assert.Nil(t, rt.RunUntilBreak())
+	assert.Equal(t, fn+5, rt.Pc())
+	rt.ClearBreakpoint(fn)
+	rt.ClearBreakpoint(fn + 5)
+	assert.Empty(t, rt.Breakpoints())
+	assert.Nil(t, rt.RunUntilBreak())
+	assert.True(t, rt.Exited())
+	assert.Equal(t, Integer(2), rt.Register(R1))
+
+	// 命令でない場所には置けない
+	assert.NotNil(t, rt.SetBreakpoint(fn+1))
+	assert.NotNil(t, rt.SetBreakpoint(-1))
+	assert.NotNil(t, rt.SetBreakpoint(len(rt.Program())))
+	_, err = rt.SetBreakpointAtLabel(Label(9))
+	assert.NotNil(t, err)
+
+	// 位置を調べるだけならブレークポイントは置かない
+	pc, err := rt.LabelPc(Label(1))
+	assert.Nil(t, err)
+	assert.Equal(t, fn, pc)
+	assert.Empty(t, rt.Breakpoints())
+	_, err = rt.LabelPc(Label(9))
+	assert.NotNil(t, err)
+}
+
+func TestRuntime_Frames(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(fnProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.SetBreakpoint(5+5)) // l_1の1つ目のadd
+	assert.Nil(t, rt.RunUntilBreak())
+
+	// startup <- main <- l_1
+	frames := rt.Frames()
+	assert.Len(t, frames, 3)
+	assert.Equal(t, Frame{Pc: 10, Bp: 5}, frames[0])
+	assert.Equal(t, Frame{Pc: 33, Bp: 7}, frames[1]) // call l_1の次
+	assert.Equal(t, Frame{Pc: 3, Bp: 0}, frames[2])  // call mainの次
+
+	// 上から bp(main), 戻り先, bp(0), 戻り先
+	assert.Equal(t, []Object{Integer(7), ProgramAbsoluteOffset(33), Integer(0), ProgramAbsoluteOffset(3)}, rt.Stack())
+}
+
+func TestRuntime_Inspect(t *testing.T) {
+	rt := NewRuntime(10, 10)
+	rt.Load(fnProgram())
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.SetBreakpoint(5+5))
+	assert.Nil(t, rt.RunUntilBreak())
+	assert.Nil(t, rt.mem.Set(MemoryOffset(2), String("x")))
+
+	tests := []struct {
+		obj    Object
+		expect Object
+	}{
+		{R1, Integer(0)},
+		{*NewBPOffset(0), Integer(7)},
+		{NewBPOffset(1), ProgramAbsoluteOffset(33)},
+		{MemoryOffset(2), String("x")},
+		{*NewMemoryRelativeOffset(R1, 2), String("x")},
+		{MemoryOffset(3), nil},
+		{Integer(5), Integer(5)},
+	}
+	for _, tt := range tests {
+		v, err := rt.Inspect(tt.obj)
+		assert.Nil(t, err, tt.obj)
+		assert.Equal(t, tt.expect, v, tt.obj)
+	}
+	for _, obj := range []Object{*NewBPOffset(9), MemoryOffset(10), Register(99), Constant(0)} {
+		_, err := rt.Inspect(obj)
+		assert.NotNil(t, err, obj)
+	}
+}
@@ -1,5 +1,10 @@
 package runtime
 
+import (
+	"strconv"
+	"strings"
+)
+
 type SystemCall int
 
 func (s SystemCall) Value() int {
@@ -9,11 +14,48 @@ func (s SystemCall) String() string {
 	switch s {
 	case Write:
 		return "write"
+	case Alloc:
+		return "alloc"
+	case Free:
+		return "free"
+	case GarbageCollect:
+		return "gc"
+	case ReadChar:
+		return "readchar"
+	case ReadLine:
+		return "readline"
+	case ReadInt:
+		return "readint"
 	default:
+		if s >= HostSystemCallBase { // ホスト関数は番号で書く
+			return "syscall_" + strconv.Itoa(s.Value())
+		}
 		return ""
 	}
 }
 
+// 引数は常に2つ, 使わないものにはNullを置く
 const (
-	Write SystemCall = iota
+	Write          SystemCall = iota // SYSCALL WRITE STDIO VALUE
+	Alloc                            // SYSCALL ALLOC REG SIZE, REGに確保した領域のListが入る
+	Free                             // SYSCALL FREE LIST NULL
+	GarbageCollect                   // SYSCALL GC NULL NULL
+	ReadChar                         // SYSCALL READCHAR STDIN REG, 入力が終わっていればREGにNullが入る
+	ReadLine                         // SYSCALL READLINE STDIN REG, 改行を除いたStringが入る
+	ReadInt                          // SYSCALL READINT STDIN REG
 )
+
+// LookupSystemCall 名前からシステムコールを探す
+func LookupSystemCall(name string) (SystemCall, bool) {
+	for _, s := range []SystemCall{Write, Alloc, Free, GarbageCollect, ReadChar, ReadLine, ReadInt} {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "syscall_")); err == nil && strings.HasPrefix(name, "syscall_") {
+		if s := SystemCall(n); s >= HostSystemCallBase {
+			return s, true
+		}
+	}
+	return 0, false
+}
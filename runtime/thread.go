@@ -0,0 +1,218 @@
+package runtime
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// defaultTimeSlice WithTimeSliceを指定しなければ, この数の命令ごとにスレッドを切り替える
+const defaultTimeSlice = 100
+
+// thread Spawnで作った実行の流れ, 番号0はmainを実行するもの
+// レジスタとスタックはスレッドごとに持ち, メモリとヒープは共有する
+type thread struct {
+	id    int
+	label Label // 実行を始めたラベル, mainならLabel(0)
+	reg   []Object
+	stack []Object
+	join  int // Joinで終わるのを待っているスレッド, 待っていなければ-1
+}
+
+// scheduler スレッドを順番に切り替える
+// 最初にSpawnしたときに作り, それまではスレッドの切り替えを何もしない
+type scheduler struct {
+	queue  []*thread      // 先頭が実行中, 後ろは順番を待っているものとJoinで待っているもの
+	done   map[int]Object // 終わったスレッドのr10
+	nextID int
+	left   int  // 今のスレッドが切り替わるまでに実行できる命令の数
+	yield  bool // 今のスレッドがYieldを実行した
+}
+
+// WithTimeSlice 同じスレッドで続けて実行する命令の数, 0ならdefaultTimeSlice
+func WithTimeSlice(n int) Option {
+	return func(r *Runtime) {
+		r.timeSlice = n
+	}
+}
+
+// WithSchedulerSeed 切り替えるまでの命令の数を1からタイムスライスの間で選ぶ
+// 順番は変わらず, 同じseedなら同じところで切り替わる
+func WithSchedulerSeed(seed uint64) Option {
+	return func(r *Runtime) {
+		r.pcg = rand.NewPCG(seed, 0)
+	}
+}
+
+// Thread 実行中のスレッドの番号, mainは0
+func (r *Runtime) Thread() int {
+	if r.sched == nil {
+		return 0
+	}
+	return r.sched.queue[0].id
+}
+
+// threadLabel 実行中のスレッドを始めたラベル
+func (r *Runtime) threadLabel() Label {
+	if r.sched == nil {
+		return Label(0)
+	}
+	return r.sched.queue[0].label
+}
+
+// Threads 終わっていないスレッドの番号を小さい順に返す
+func (r *Runtime) Threads() []int {
+	if r.sched == nil {
+		return []int{0}
+	}
+	ids := make([]int, 0, len(r.sched.queue))
+	for id := 0; id < r.sched.nextID; id++ {
+		if _, ok := r.sched.done[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (r *Runtime) nextTimeSlice() int {
+	n := r.timeSlice
+	if n <= 0 {
+		n = defaultTimeSlice
+	}
+	if r.pcg != nil {
+		return 1 + int(r.pcg.Uint64()%uint64(n))
+	}
+	return n
+}
+
+// spawn labelの最初の命令pcから実行するスレッドを作って順番待ちの最後に入れる
+// r0からacm2は引数として今のスレッドのものを写す
+// 最初の関数から戻ると起動用のコードのexitに当たってそのスレッドが終わる
+func (r *Runtime) spawn(label Label, pc int) (int, error) {
+	exit, err := r.threadExit()
+	if err != nil {
+		return 0, err
+	}
+	if len(r.stack) < 2 {
+		return 0, errorf(StackOverflow, "stack is too small for a thread: stack_size=%d", len(r.stack))
+	}
+	if r.sched == nil {
+		r.sched = &scheduler{
+			queue:  []*thread{{id: 0, reg: r.reg, stack: r.stack, join: -1}},
+			done:   map[int]Object{},
+			nextID: 1,
+			left:   r.nextTimeSlice(),
+		}
+	}
+	t := &thread{
+		id:    r.sched.nextID,
+		label: label,
+		reg:   *NewRegisterSet(),
+		stack: make([]Object, len(r.stack)),
+		join:  -1,
+	}
+	copy(t.reg[R0:], r.reg[R0:])
+	sp := len(t.stack) - 2 // 一番下は番兵
+	t.stack[sp] = ProgramAbsoluteOffset(exit)
+	t.reg[ProgramCounter] = Integer(pc)
+	t.reg[BasePointer] = Integer(0)
+	t.reg[StackPointer] = Integer(sp)
+	r.sched.nextID++
+	r.sched.queue = append(r.sched.queue, t)
+	return t.id, nil
+}
+
+// threadExit 起動用のコードでcall mainから戻る位置, そこにexitがある
+func (r *Runtime) threadExit() (int, error) {
+	entry, err := r.sym.Get(Label(-1))
+	if err != nil {
+		return 0, err
+	}
+	call := r.program.firstOpcode(entry.Value())
+	if call < 0 || r.program[call] != Call {
+		return 0, errorf(PcOutOfRange, "startup code is not found")
+	}
+	return call + 1 + Operand(Call), nil
+}
+
+// join idのスレッドが終わっていればそのr10を返す, まだならfalseを返して待つ
+func (r *Runtime) join(id Object) (Object, bool, error) {
+	n, ok := id.(Integer)
+	if !ok {
+		return nil, false, errorf(BadOperand, "unsupported join target: %v", id)
+	}
+	if n.Value() == r.Thread() {
+		return nil, false, errorf(Deadlock, "thread %d joins itself", n.Value())
+	}
+	if r.sched == nil || n.Value() < 0 || r.sched.nextID <= n.Value() {
+		return nil, false, errorf(BadOperand, "no such thread: %d", n.Value())
+	}
+	t := r.sched.queue[0]
+	if v, ok := r.sched.done[n.Value()]; ok {
+		t.join = -1
+		return v, true, nil
+	}
+	t.join = n.Value()
+	return nil, false, nil
+}
+
+// schedule 命令を1つ実行した後に, 必要ならスレッドを切り替える
+// mainが終わればほかのスレッドが残っていても全体が終わる
+// 進めるスレッドがなければ切り替えずにDeadlockのエラーを返す
+func (r *Runtime) schedule() error {
+	s := r.sched
+	t := s.queue[0]
+	var queue []*thread // 切り替えるなら今のスレッドを除いて順番に並べたもの
+	switch {
+	case r.mustExit():
+		if t.id == 0 {
+			return nil
+		}
+		queue = s.queue[1:]
+	case t.join >= 0 || s.yield:
+		queue = append(s.queue[1:len(s.queue):len(s.queue)], t)
+	default:
+		if s.left--; 0 < s.left {
+			return nil
+		}
+		queue = append(s.queue[1:len(s.queue):len(s.queue)], t)
+	}
+	exited := r.mustExit()
+	for i, next := range queue {
+		if _, ok := s.done[next.join]; next.join < 0 || ok || (exited && next.join == t.id) {
+			if exited {
+				s.done[t.id] = t.reg[R10]
+			}
+			s.queue = append(append([]*thread{}, queue[i:]...), queue[:i]...)
+			s.yield = false
+			s.left = r.nextTimeSlice()
+			r.reg, r.stack = next.reg, next.stack
+			return nil
+		}
+	}
+	return errorf(Deadlock, "all threads are waiting: %v", r.waiting())
+}
+
+// waiting Joinで待っているスレッドと待たれているスレッド
+func (r *Runtime) waiting() string {
+	var msg string
+	for _, t := range r.sched.queue {
+		if msg != "" {
+			msg += ", "
+		}
+		msg += fmt.Sprintf("%d->%d", t.id, t.join)
+	}
+	return msg
+}
+
+// resetThreads mainのスレッドに戻してほかのスレッドを捨てる
+func (r *Runtime) resetThreads() {
+	if r.sched == nil {
+		return
+	}
+	for _, t := range r.sched.queue {
+		if t.id == 0 {
+			r.reg, r.stack = t.reg, t.stack
+		}
+	}
+	r.sched = nil
+}
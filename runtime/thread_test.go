@@ -0,0 +1,360 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// producerConsumerProgram 1つが1から3をmem[0]に置き, もう1つがそれを読んで書き出す
+// mem[1]が読まれるのを待っている印で, 待つ間はYieldする
+func producerConsumerProgram() Program {
+	return Program{
+		// producer:
+		DefLabel(1),
+		Mov, R2, Integer(1),
+		DefLabel(3),
+		Load, R3, MemoryOffset(1),
+		Beq, R3, True, Label(4),
+		Store, MemoryOffset(0), R2,
+		Store, MemoryOffset(1), True,
+		Add, R2, Integer(1),
+		Ble, R2, Integer(3), Label(3),
+		Ret,
+		DefLabel(4),
+		Yield,
+		Jmp, Label(3),
+
+		// consumer:
+		DefLabel(2),
+		Mov, R4, Integer(0), // 受け取った数
+		DefLabel(5),
+		Load, R3, MemoryOffset(1),
+		Bne, R3, True, Label(6),
+		Load, R5, MemoryOffset(0),
+		Syscall, Write, StdOut, R5,
+		Store, MemoryOffset(1), False,
+		Add, R4, Integer(1),
+		Blt, R4, Integer(3), Label(5),
+		Mov, R10, R4,
+		Ret,
+		DefLabel(6),
+		Yield,
+		Jmp, Label(5),
+
+		// main:
+		DefLabel(0),
+		Store, MemoryOffset(1), False,
+		Spawn, Label(1),
+		Mov, R6, R10,
+		Spawn, Label(2),
+		Mov, R7, R10,
+		Join, R6,
+		Join, R7,
+		Mov, ACM1, R10, // consumerが受け取った数
+		Ret,
+	}
+}
+
+func TestRuntime_Run_Thread(t *testing.T) {
+	for _, opts := range [][]Option{
+		{},
+		{WithTimeSlice(1)},
+		{WithTimeSlice(3)},
+		{WithSchedulerSeed(1), WithTimeSlice(5)},
+		{WithSchedulerSeed(42), WithTimeSlice(5)},
+	} {
+		var out bytes.Buffer
+		rt := NewRuntime(20, 4, append(opts, WithStdout(&out))...)
+		assert.Nil(t, rt.Load(producerConsumerProgram()))
+		assert.Nil(t, rt.CollectLabels())
+		assert.Nil(t, rt.Run())
+		assert.Equal(t, "123", out.String())
+		assert.Equal(t, 3, rt.Status())
+		assert.Equal(t, Integer(1), rt.reg[R6])
+		assert.Equal(t, Integer(2), rt.reg[R7])
+		assert.Equal(t, 0, rt.Thread())
+		assert.Equal(t, []int{0}, rt.Threads())
+	}
+}
+
+// 1命令ずつ実行しても切り替わり方は変わらない
+func TestRuntime_Step_Thread(t *testing.T) {
+	var fastOut, slowOut bytes.Buffer
+	fast := NewRuntime(20, 4, WithStdout(&fastOut), WithTimeSlice(2))
+	slow := NewRuntime(20, 4, WithStdout(&slowOut), WithTimeSlice(2))
+	for _, rt := range []*Runtime{fast, slow} {
+		assert.Nil(t, rt.Load(producerConsumerProgram()))
+		assert.Nil(t, rt.CollectLabels())
+	}
+	assert.Nil(t, fast.Run())
+	threads := map[int]bool{}
+	for !slow.Exited() {
+		if !assert.Nil(t, slow.Step()) {
+			return
+		}
+		threads[slow.Thread()] = true
+	}
+	assert.Equal(t, map[int]bool{0: true, 1: true, 2: true}, threads)
+	assert.Equal(t, fastOut.String(), slowOut.String())
+	assert.Equal(t, fast.Executed(), slow.Executed())
+	assert.Equal(t, fast.reg, slow.reg)
+}
+
+func interleaveProgram() Program {
+	return Program{
+		DefLabel(1),
+		Mov, R2, Integer(0),
+		DefLabel(3),
+		Syscall, Write, StdOut, R1,
+		Add, R2, Integer(1),
+		Blt, R2, Integer(5), Label(3),
+		Ret,
+		DefLabel(0),
+		Mov, R1, Character('a'), // r0からacm2は引数として渡る
+		Spawn, Label(1),
+		Mov, R3, R10,
+		Mov, R1, Character('b'),
+		Spawn, Label(1),
+		Mov, R4, R10,
+		Join, R3,
+		Join, R4,
+		Ret,
+	}
+}
+
+func TestRuntime_Run_Thread_Seed(t *testing.T) {
+	run := func(opts ...Option) string {
+		var out bytes.Buffer
+		rt := NewRuntime(20, 4, append(opts, WithStdout(&out))...)
+		rt.Load(interleaveProgram())
+		assert.Nil(t, rt.CollectLabels())
+		assert.Nil(t, rt.Run())
+		return out.String()
+	}
+	// 切り替わる前に終わる
+	assert.Equal(t, "aaaaabbbbb", run())
+	// 3命令ずつ順番に進む, mainがJoinで待つまではaのスレッドとmainが交互
+	assert.Equal(t, "aababababb", run(WithTimeSlice(3)))
+
+	seen := map[string]bool{}
+	for seed := uint64(0); seed < 10; seed++ {
+		out := run(WithSchedulerSeed(seed), WithTimeSlice(8))
+		assert.Equal(t, out, run(WithSchedulerSeed(seed), WithTimeSlice(8)), "seed=%d", seed)
+		assert.Equal(t, 5, bytes.Count([]byte(out), []byte("a")))
+		assert.Equal(t, 5, bytes.Count([]byte(out), []byte("b")))
+		seen[out] = true
+	}
+	assert.Less(t, 1, len(seen))
+}
+
+func TestRuntime_Run_Thread_Return(t *testing.T) {
+	rt := NewRuntime(10, 4)
+	rt.Load(Program{
+		DefLabel(1),
+		Push, BasePointer,
+		Mov, BasePointer, StackPointer,
+		Mov, R10, R1,
+		Add, R10, Integer(1),
+		Pop, BasePointer,
+		Ret,
+		DefLabel(0),
+		Mov, R1, Integer(20),
+		Spawn, Label(1),
+		Mov, R1, Integer(0), // 渡した後に変えても影響しない
+		Join, R10,
+		Mov, ACM1, R10,
+		Join, Integer(1), // 終わったスレッドは何度でも待てる
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 21, rt.Status())
+	assert.Equal(t, Integer(21), rt.reg[R10])
+}
+
+// mainが終われば残りのスレッドも終わる
+func TestRuntime_Run_Thread_MainExit(t *testing.T) {
+	rt := NewRuntime(10, 4, WithTimeSlice(2))
+	rt.Load(Program{
+		DefLabel(1),
+		Jmp, Label(1),
+		DefLabel(0),
+		Spawn, Label(1),
+		Yield,
+		Mov, ACM1, Integer(7),
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.True(t, rt.Exited())
+	assert.Equal(t, 7, rt.Status())
+	assert.Equal(t, []int{0, 1}, rt.Threads())
+
+	// もう一度Runすればスレッドを作る前から始める
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 7, rt.Status())
+}
+
+func TestRuntime_Run_Thread_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		prog Program
+		kind ErrorKind
+		pc   int
+	}{
+		{"join itself", Program{DefLabel(0), Join, Integer(0), Ret}, Deadlock, 5},
+		{"no such thread", Program{DefLabel(0), Join, Integer(1), Ret}, BadOperand, 5},
+		{"join with char", Program{DefLabel(0), Join, Character('a'), Ret}, BadOperand, 5},
+		{
+			"deadlock",
+			Program{
+				DefLabel(1),
+				Join, Integer(0),
+				Ret,
+				DefLabel(0),
+				Spawn, Label(1),
+				Join, R10,
+				Ret,
+			},
+			Deadlock, 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRuntime(10, 4)
+			rt.Load(tt.prog)
+			assert.Nil(t, rt.CollectLabels())
+			err := rt.Run()
+			var re *RuntimeError
+			if assert.True(t, errors.As(err, &re), "%v", err) {
+				assert.Equal(t, tt.kind, re.Kind)
+				assert.Equal(t, tt.pc, re.Pc)
+				assert.Equal(t, Join, re.Opcode)
+			}
+		})
+	}
+}
+
+func TestRuntime_Run_Yield(t *testing.T) {
+	// スレッドがなければ何もしない
+	rt := NewRuntime(10, 4)
+	rt.Load(Program{DefLabel(0), Yield, Mov, ACM1, Integer(1), Ret})
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+	assert.Equal(t, 1, rt.Status())
+}
+
+// 止まっているスレッドのレジスタと, 受け取られていない戻り値から辿れる領域は回収しない
+func TestRuntime_GC_Thread(t *testing.T) {
+	tests := []struct {
+		name   string
+		worker Program
+	}{
+		{
+			"suspended thread",
+			Program{
+				DefLabel(1),
+				Syscall, Alloc, R1, Integer(1),
+				Store, MemoryRelativeOffset{R1, 0}, Integer(42),
+				Yield,
+				Load, R10, MemoryRelativeOffset{R1, 0},
+				Ret,
+			},
+		},
+		{
+			"exited thread",
+			Program{
+				DefLabel(1),
+				Syscall, Alloc, R1, Integer(1),
+				Store, MemoryRelativeOffset{R1, 0}, Integer(42),
+				Mov, R10, R1,
+				Ret,
+			},
+		},
+	}
+	main := Program{
+		DefLabel(0),
+		Spawn, Label(1),
+		Mov, R6, R10,
+		Yield, // workerが確保するまで待つ
+		Syscall, GarbageCollect, Null{}, Null{},
+		Syscall, Alloc, R3, Integer(1),
+		Store, MemoryRelativeOffset{R3, 0}, Integer(7),
+		Join, R6,
+		Ret,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, step := range []bool{false, true} {
+				rt := NewRuntime(10, 4)
+				rt.Load(append(append(Program{}, tt.worker...), main...))
+				assert.Nil(t, rt.CollectLabels())
+				if step {
+					for !rt.Exited() {
+						if !assert.Nil(t, rt.Step()) {
+							return
+						}
+					}
+				} else {
+					assert.Nil(t, rt.Run())
+				}
+				v := rt.reg[R10]
+				if ref, ok := v.(List); ok {
+					v = rt.mem[ref.Value()]
+				}
+				assert.Equal(t, Integer(42), v)
+				assert.Equal(t, List(1), rt.reg[R3])
+				assert.Equal(t, 0, rt.GCStats().Freed)
+			}
+		})
+	}
+}
+
+// スレッドがあっても, 途中で写して別のRuntimeで続ければ同じ結果になる
+func TestRuntime_Snapshot_Thread(t *testing.T) {
+	for _, tt := range []struct {
+		opts    []Option
+		restore []Option // 写した先のRuntimeの設定
+	}{
+		{[]Option{WithTimeSlice(2)}, []Option{WithTimeSlice(2)}},
+		// 乱数の状態も写すので, 別のseedで作っても同じように切り替わる
+		{[]Option{WithTimeSlice(5), WithSchedulerSeed(7)}, []Option{WithTimeSlice(5), WithSchedulerSeed(99)}},
+	} {
+		opts := tt.opts
+		var expect bytes.Buffer
+		whole := NewRuntime(20, 4, append(opts, WithStdout(&expect))...)
+		whole.Load(producerConsumerProgram())
+		assert.Nil(t, whole.CollectLabels())
+		assert.Nil(t, whole.Run())
+
+		for _, fuel := range []int{5, 20, 37, 60} {
+			var before, after bytes.Buffer
+			rt := NewRuntime(20, 4, append(opts, WithStdout(&before), WithFuel(fuel))...)
+			rt.Load(producerConsumerProgram())
+			assert.Nil(t, rt.CollectLabels())
+			var halt *HaltError
+			if !assert.True(t, errors.As(rt.Run(), &halt), "fuel=%d", fuel) {
+				continue
+			}
+			snap, err := rt.Snapshot()
+			assert.Nil(t, err)
+			assert.NotNil(t, snap.Scheduler)
+			data, err := snap.MarshalBinary()
+			assert.Nil(t, err)
+
+			var decoded Snapshot
+			assert.Nil(t, decoded.UnmarshalBinary(data))
+			assert.Equal(t, snap, &decoded)
+			restored := NewRuntime(20, 4, append(tt.restore, WithStdout(&after))...)
+			assert.Nil(t, restored.Restore(&decoded))
+			assert.Equal(t, rt.Thread(), restored.Thread())
+			assert.Equal(t, rt.Threads(), restored.Threads())
+			assert.Nil(t, restored.Continue())
+			assert.Equal(t, expect.String(), before.String()+after.String(), "fuel=%d", fuel)
+			assert.Equal(t, whole.Status(), restored.Status())
+			assert.Equal(t, whole.Executed(), halt.Executed+restored.Executed())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TraceEvent 命令を1つ実行する直前の状態
+// RegsとStackは前のイベントから変わったところだけを持つ
+type TraceEvent struct {
+	Step     int // 何番目に実行する命令か, 0から数える
+	Pc       int
+	Opcode   Opcode
+	Operands []Object
+	Regs     []RegisterDelta // pcは毎回変わるので含めない
+	Stack    []StackDelta    // 位置の小さい順
+}
+
+// RegisterDelta 変わったレジスタ, 空になった場合Valueはnil
+type RegisterDelta struct {
+	Register Register
+	Value    Object
+}
+
+// StackDelta 変わったスタックの位置, 空になった場合Valueはnil
+type StackDelta struct {
+	Index int
+	Value Object
+}
+
+// TraceHook 命令の実行前に呼ばれる, エラーを返すと実行を止める
+type TraceHook func(ev TraceEvent) error
+
+// tracer 差分を取るために前のイベントの状態を持つ
+type tracer struct {
+	hook  TraceHook
+	step  int
+	reg   []Object
+	stack []Object
+	sp    int
+}
+
+// SetTraceHook 命令ごとに呼ばれるフックを設定する, nilで止める
+func (r *Runtime) SetTraceHook(hook TraceHook) {
+	if hook == nil {
+		r.trace = nil
+		return
+	}
+	r.trace = &tracer{
+		hook:  hook,
+		reg:   append([]Object{}, r.reg...),
+		stack: append([]Object{}, r.stack...),
+		sp:    r.sp(),
+	}
+}
+
+// emitTrace pcの命令についてフックを呼ぶ
+func (r *Runtime) emitTrace() error {
+	t := r.trace
+	op := r.program[r.pc()].(Opcode)
+	ev := TraceEvent{
+		Step:     t.step,
+		Pc:       r.pc(),
+		Opcode:   op,
+		Operands: append([]Object{}, r.program[r.pc()+1:min(r.pc()+1+Operand(op), len(r.program))]...),
+	}
+	for i, obj := range ev.Operands {
+		if c, ok := obj.(Constant); ok { // 定数は値に戻す
+			if v, err := r.consts.Get(c); err == nil {
+				ev.Operands[i] = v
+			}
+		}
+	}
+	for reg, v := range r.reg {
+		if Register(reg) != ProgramCounter && v != t.reg[reg] {
+			ev.Regs = append(ev.Regs, RegisterDelta{Register(reg), v})
+			t.reg[reg] = v
+		}
+	}
+	if len(t.stack) != len(r.stack) { // Restoreで大きさが変わったら空のスタックと比べる
+		t.stack = make([]Object, len(r.stack))
+		t.sp = 0
+	}
+	// スタックが変わるのはspより上だけ
+	for i := max(min(t.sp, r.sp()), 0); i < len(r.stack); i++ {
+		if r.stack[i] != t.stack[i] {
+			ev.Stack = append(ev.Stack, StackDelta{i, r.stack[i]})
+			t.stack[i] = r.stack[i]
+		}
+	}
+	t.sp = r.sp()
+	t.step++
+	return t.hook(ev)
+}
+
+// jsonTraceEvent JSON Linesの1行
+type jsonTraceEvent struct {
+	Step     int                `json:"step"`
+	Pc       int                `json:"pc"`
+	Opcode   string             `json:"op"`
+	Operands []string           `json:"operands"`
+	Regs     map[string]*string `json:"regs,omitempty"`
+	Stack    map[string]*string `json:"stack,omitempty"`
+}
+
+// NewJSONTraceHook イベントを1行ずつJSONで書き出すフック
+// 値はアセンブリに近い文字列で, 空になったものはnullになる
+func NewJSONTraceHook(w io.Writer) TraceHook {
+	enc := json.NewEncoder(w)
+	return func(ev TraceEvent) error {
+		line := jsonTraceEvent{
+			Step:     ev.Step,
+			Pc:       ev.Pc,
+			Opcode:   strings.ToLower(ev.Opcode.String()),
+			Operands: make([]string, len(ev.Operands)),
+		}
+		for i, obj := range ev.Operands {
+			line.Operands[i] = *traceValue(obj)
+		}
+		if len(ev.Regs) > 0 {
+			line.Regs = map[string]*string{}
+			for _, d := range ev.Regs {
+				line.Regs[d.Register.String()] = traceValue(d.Value)
+			}
+		}
+		if len(ev.Stack) > 0 {
+			line.Stack = map[string]*string{}
+			for _, d := range ev.Stack {
+				line.Stack[strconv.Itoa(d.Index)] = traceValue(d.Value)
+			}
+		}
+		return enc.Encode(line)
+	}
+}
+
+// traceValue 文字と文字列は引用符で囲み, ラベルはl_3のように書く. nilならnil
+func traceValue(obj Object) *string {
+	var s string
+	switch obj := obj.(type) {
+	case nil:
+		return nil
+	case Character:
+		s = strconv.QuoteRune(rune(obj))
+	case String:
+		s = strconv.Quote(string(obj))
+	case Opcode:
+		s = strings.ToLower(obj.String())
+	case Label:
+		s = "l_" + obj.String()
+	default:
+		s = obj.String()
+	}
+	return &s
+}
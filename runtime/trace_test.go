@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRuntime_SetTraceHook(t *testing.T) {
+	rt := NewRuntime(5, 1)
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Integer(1),
+		Push, R1,
+		Pop, R2,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	var events []TraceEvent
+	rt.SetTraceHook(func(ev TraceEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	assert.Nil(t, rt.Run())
+
+	assert.Len(t, events, 6) // call, mov, push, pop, ret, exit
+	assert.Equal(t, TraceEvent{Step: 0, Pc: 1, Opcode: Call, Operands: []Object{Label(0)}}, events[0])
+	assert.Equal(t, TraceEvent{
+		Step: 1, Pc: 5, Opcode: Mov, Operands: []Object{R1, Integer(1)},
+		Regs:  []RegisterDelta{{StackPointer, Integer(3)}},
+		Stack: []StackDelta{{3, ProgramAbsoluteOffset(3)}},
+	}, events[1])
+	assert.Equal(t, []RegisterDelta{{R1, Integer(1)}}, events[2].Regs)
+	assert.Nil(t, events[2].Stack)
+	assert.Equal(t, []StackDelta{{2, Integer(1)}}, events[3].Stack)
+	// popした場所は空になる
+	assert.Equal(t, []RegisterDelta{{StackPointer, Integer(3)}, {R2, Integer(1)}}, events[4].Regs)
+	assert.Equal(t, []StackDelta{{2, nil}}, events[4].Stack)
+
+	// エラーを返すと止まる
+	rt = NewRuntime(5, 1)
+	rt.Load(Program{DefLabel(0), Ret})
+	assert.Nil(t, rt.CollectLabels())
+	errStop := errors.New("stop")
+	rt.SetTraceHook(func(ev TraceEvent) error { return errStop })
+	assert.ErrorIs(t, rt.Run(), errStop)
+	// nilで外せる
+	rt.SetTraceHook(nil)
+	assert.Nil(t, rt.Run())
+}
+
+func TestNewJSONTraceHook(t *testing.T) {
+	var buf bytes.Buffer
+	rt := NewRuntime(5, 1, WithStdout(&bytes.Buffer{}))
+	rt.Load(Program{
+		DefLabel(0),
+		Mov, R1, Character('a'),
+		Push, String("hi"),
+		Pop, R2,
+		Syscall, Write, StdOut, R2,
+		Ret,
+	})
+	assert.Nil(t, rt.CollectLabels())
+	rt.SetTraceHook(NewJSONTraceHook(&buf))
+	assert.Nil(t, rt.Run())
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(t, []string{
+		`{"step":0,"pc":1,"op":"call","operands":["l_0"]}`,
+		`{"step":1,"pc":5,"op":"mov","operands":["r1","'a'"],"regs":{"sp":"3"},"stack":{"3":"pc(3)"}}`,
+		`{"step":2,"pc":8,"op":"push","operands":["\"hi\""],"regs":{"r1":"'a'"}}`,
+		`{"step":3,"pc":10,"op":"pop","operands":["r2"],"regs":{"sp":"2"},"stack":{"2":"\"hi\""}}`,
+		`{"step":4,"pc":12,"op":"syscall","operands":["write","stdout","r2"],"regs":{"r2":"\"hi\"","sp":"3"},"stack":{"2":null}}`,
+		`{"step":5,"pc":16,"op":"ret","operands":[]}`,
+		`{"step":6,"pc":3,"op":"exit","operands":[],"regs":{"sp":"4"},"stack":{"3":null}}`,
+	}, lines)
+}
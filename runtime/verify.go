@@ -0,0 +1,294 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VerifyError Verifyで見つかった問題
+// pcはLoadに渡す前のプログラムの中の位置で, Loadが先頭に足す起動用のコード(4つ)は数えない
+type VerifyError struct {
+	Pc  int
+	Msg string
+}
+
+func (e VerifyError) Error() string {
+	return fmt.Sprintf("pc=%d: %s", e.Pc, e.Msg)
+}
+
+// VerifyErrors Verifyで見つかった問題すべて, pcの順
+type VerifyErrors []VerifyError
+
+func (e VerifyErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// operandRole 命令がオペランドに求めるもの
+type operandRole int
+
+const (
+	roleLabel     operandRole = iota // 飛び先
+	roleRegister                     // 書き込み先のレジスタ
+	roleDest                         // レジスタかスタック
+	roleValue                        // 値として読めるもの
+	roleComparand                    // 比較命令の値, レジスタか即値
+	roleMemory                       // メモリの位置
+	roleSyscall                      // システムコールの番号か名前
+	roleAny                          // システムコールの引数, 何が来るかは番号次第
+)
+
+func (role operandRole) String() string {
+	switch role {
+	case roleLabel:
+		return "label"
+	case roleRegister:
+		return "register"
+	case roleDest:
+		return "register or stack offset"
+	case roleValue:
+		return "value"
+	case roleComparand:
+		return "register or immediate"
+	case roleMemory:
+		return "memory offset"
+	case roleSyscall:
+		return "system call"
+	default:
+		return "operand"
+	}
+}
+
+// operandRoles 命令ごとのオペランドの役割, 数はOperandと揃える
+func operandRoles(op Opcode) []operandRole {
+	switch op {
+	case Call, Jmp, Je, Jne, Spawn:
+		return []operandRole{roleLabel}
+	case Push, Join:
+		return []operandRole{roleValue}
+	case Pop, Not, Neg, Itof, Ftoi:
+		return []operandRole{roleRegister}
+	case Mov:
+		return []operandRole{roleDest, roleValue}
+	case Add, Sub, Mul, Div, Mod, And, Or, Xor, Shl, Shr, Concat, Len:
+		return []operandRole{roleRegister, roleValue}
+	case Eq, Ne, Lt, Le, Gt, Ge:
+		return []operandRole{roleComparand, roleComparand}
+	case Load:
+		return []operandRole{roleRegister, roleMemory}
+	case Store:
+		return []operandRole{roleMemory, roleValue}
+	case Beq, Bne, Blt, Ble, Bgt, Bge:
+		return []operandRole{roleComparand, roleComparand, roleLabel}
+	case Index, Substr:
+		return []operandRole{roleRegister, roleValue, roleValue}
+	case Syscall:
+		return []operandRole{roleSyscall, roleAny, roleAny}
+	default:
+		return nil
+	}
+}
+
+// Verify 実行する前にプログラムの形を確かめる
+// オペランドの数と種類, ラベルの解決, DefLabelの重複を調べ,
+// 入口のmain(l_0)から辿れる命令がretなどで止まらずにプログラムの終わりを越えないかを調べる
+// 見つかった問題をすべてVerifyErrorsで返す
+func Verify(prog Program) error {
+	var errs VerifyErrors
+	report := func(pc int, format string, args ...any) {
+		errs = append(errs, VerifyError{pc, fmt.Sprintf(format, args...)})
+	}
+
+	defined := map[Label]int{Label(-1): -1} // l_-1はLoadが足す起動用のコードで定義する
+	type ref struct {
+		pc    int
+		label Label
+	}
+	var refs []ref
+	for pc := 0; pc < len(prog); {
+		switch code := prog[pc].(type) {
+		case DefLabel:
+			label := Label(code.Value())
+			if at, ok := defined[label]; ok {
+				if at < 0 {
+					report(pc, "l_%d is reserved for startup code", label.Value())
+				} else {
+					report(pc, "l_%d is already defined at pc=%d", label.Value(), at)
+				}
+			} else {
+				defined[label] = pc
+			}
+			pc++
+		case Opcode:
+			if code < 0 || len(opcodeKinds) <= int(code) {
+				report(pc, "unknown opcode: %d", int(code))
+				pc++
+				continue
+			}
+			n := Operand(code)
+			if len(prog) <= pc+n {
+				report(pc, "%v takes %d operands, but program ends", code, n)
+				pc = len(prog)
+				continue
+			}
+			for i, role := range operandRoles(code) {
+				obj := prog[pc+1+i]
+				if msg := checkOperand(role, obj); msg != "" {
+					report(pc+1+i, "%v operand %d: %s", code, i+1, msg)
+				}
+				if label, ok := obj.(Label); ok && role == roleLabel {
+					refs = append(refs, ref{pc + 1 + i, label})
+				}
+			}
+			pc += 1 + n
+		default:
+			report(pc, "expect opcode or label, but got: %v", describe(code))
+			pc++
+		}
+	}
+
+	for _, ref := range refs {
+		if _, ok := defined[ref.label]; !ok {
+			report(ref.pc, "l_%d is not defined", ref.label.Value())
+		}
+	}
+	if at, ok := defined[Label(0)]; !ok {
+		report(len(prog), "entry point main(l_0) is not defined")
+	} else if entry := prog.firstOpcode(at + 1); entry < 0 {
+		report(at, "entry point main(l_0) has no instruction")
+	} else {
+		for _, pc := range fallsOffEnd(prog, defined, entry) {
+			report(pc, "%v reachable from main(l_0) runs past the end of the program", prog[pc])
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// ラベルの問題は後から見つかるのでpcの順に並べ直す
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pc < errs[j].Pc })
+	return errs
+}
+
+// checkOperand 役割に合わなければ理由を返す
+func checkOperand(role operandRole, obj Object) string {
+	switch obj := obj.(type) {
+	case nil, Opcode, DefLabel:
+		return fmt.Sprintf("want %v, but got: %v", role, describe(obj))
+	case Register:
+		if obj < 0 || _reg_end <= obj {
+			return fmt.Sprintf("invalid register: %d", int(obj))
+		}
+	case StackRelativeOffset:
+		if obj.target != BasePointer && obj.target != StackPointer {
+			return fmt.Sprintf("offset base must be bp or sp: %v", obj)
+		}
+	case MemoryRelativeOffset:
+		if obj.target < 0 || _reg_end <= obj.target {
+			return fmt.Sprintf("invalid register: %d", int(obj.target))
+		}
+	}
+
+	ok := false
+	switch role {
+	case roleLabel:
+		_, ok = obj.(Label)
+	case roleRegister:
+		_, ok = obj.(Register)
+	case roleDest:
+		switch obj.(type) {
+		case Register, StackRelativeOffset:
+			ok = true
+		}
+	case roleValue:
+		switch obj.(type) {
+		case Register, StackRelativeOffset, Integer, Float, Character, Bool, Null, String, Constant:
+			ok = true
+		}
+	case roleComparand:
+		switch obj.(type) {
+		case Register, Integer, Float, Character, Bool, Null, String, Constant:
+			ok = true
+		}
+	case roleMemory:
+		switch obj.(type) {
+		case MemoryOffset, MemoryRelativeOffset:
+			ok = true
+		}
+	case roleSyscall:
+		switch obj.(type) {
+		case SystemCall, String, Constant:
+			ok = true
+		}
+	case roleAny:
+		ok = true
+	}
+	if !ok {
+		return fmt.Sprintf("want %v, but got: %v", role, describe(obj))
+	}
+	return ""
+}
+
+// describe エラーに出すために型と値を並べる
+func describe(obj Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T(%v)", obj, obj)
+}
+
+// fallsOffEnd entryから辿れる命令のうち, 次の命令がなくプログラムの終わりを越えて進むもの
+// callした先からは戻ってくるものとして, 飛び先と次の命令の両方を辿る
+func fallsOffEnd(prog Program, defined map[Label]int, entry int) []int {
+	var found []int
+	seen := map[int]bool{}
+	work := []int{entry}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+		code, ok := prog[pc].(Opcode)
+		if !ok || code < 0 || len(opcodeKinds) <= int(code) || len(prog) <= pc+Operand(code) {
+			continue // 形の問題は先に報告している
+		}
+		var label Object
+		switch code {
+		case Call, Jmp, Je, Jne, Spawn:
+			label = prog[pc+1]
+		case Beq, Bne, Blt, Ble, Bgt, Bge:
+			label = prog[pc+3]
+		}
+		if label, ok := label.(Label); ok {
+			if at, ok := defined[label]; ok && 0 <= at {
+				if dest := prog.firstOpcode(at + 1); 0 <= dest {
+					work = append(work, dest)
+				}
+			}
+		}
+		switch code {
+		case Ret, Exit, Jmp:
+			continue
+		}
+		next := pc + 1 + Operand(code)
+		for next < len(prog) {
+			if _, ok := prog[next].(DefLabel); !ok {
+				break
+			}
+			next++
+		}
+		if next == len(prog) {
+			found = append(found, pc)
+		} else if _, ok := prog[next].(Opcode); ok {
+			work = append(work, next)
+		}
+	}
+	sort.Ints(found)
+	return found
+}
@@ -0,0 +1,126 @@
+package runtime
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	for _, prog := range []Program{
+		fizzBuzzProgram(),
+		fibonacciProgram(10),
+		fnProgram(),
+		loopProgram(),
+		countdownProgram(),
+		recursiveProgram(),
+		producerConsumerProgram(),
+		{DefLabel(0), Mov, R1, String("a"), Syscall, String("config.get"), R1, Null{}, Store, MemoryOffset(1), R1, Ret},
+		{DefLabel(0), Ret, DefLabel(1), Add, R1, Integer(1)}, // mainから辿れなければ終わりを越えてもよい
+	} {
+		assert.Nil(t, Verify(prog))
+	}
+}
+
+func TestVerify_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		expect VerifyErrors
+	}{
+		{
+			"call with integer",
+			Program{DefLabel(0), Call, Integer(1), Ret},
+			VerifyErrors{{2, "Call operand 1: want label, but got: runtime.Integer(1)"}},
+		},
+		{
+			"spawn with register",
+			Program{DefLabel(0), Spawn, R1, Join, R10, Ret},
+			VerifyErrors{{2, "Spawn operand 1: want label, but got: runtime.Register(r1)"}},
+		},
+		{
+			"mov to integer",
+			Program{DefLabel(0), Mov, Integer(1), R1, Ret},
+			VerifyErrors{{2, "Mov operand 1: want register or stack offset, but got: runtime.Integer(1)"}},
+		},
+		{
+			"undefined label",
+			Program{DefLabel(0), Jmp, Label(3), Ret},
+			VerifyErrors{{2, "l_3 is not defined"}},
+		},
+		{
+			"missing operands",
+			Program{DefLabel(0), Ret, Mov, R1},
+			VerifyErrors{{2, "Mov takes 2 operands, but program ends"}},
+		},
+		{
+			"duplicated label",
+			Program{DefLabel(0), Ret, DefLabel(0), DefLabel(-1), Ret},
+			VerifyErrors{
+				{2, "l_0 is already defined at pc=0"},
+				{3, "l_-1 is reserved for startup code"},
+			},
+		},
+		{
+			"no entry point",
+			Program{DefLabel(1), Ret},
+			VerifyErrors{{2, "entry point main(l_0) is not defined"}},
+		},
+		{
+			"empty entry point",
+			Program{Ret, DefLabel(0)},
+			VerifyErrors{{1, "entry point main(l_0) has no instruction"}},
+		},
+		{
+			"runs past the end",
+			Program{DefLabel(0), Mov, R1, Integer(1)},
+			VerifyErrors{{1, "Mov reachable from main(l_0) runs past the end of the program"}},
+		},
+		{
+			"branch runs past the end",
+			Program{DefLabel(0), Je, Label(1), Ret, DefLabel(1), Call, Label(2), DefLabel(2), Add, R1, Integer(1), DefLabel(3)},
+			VerifyErrors{{8, "Add reachable from main(l_0) runs past the end of the program"}},
+		},
+		{
+			"bad operands",
+			Program{DefLabel(0), Push, Register(99), Load, R1, Integer(0), Push, *NewStackRelativeOffset(R1, 0), Integer(1), Ret},
+			VerifyErrors{
+				{2, "Push operand 1: invalid register: 99"},
+				{5, "Load operand 2: want memory offset, but got: runtime.Integer(0)"},
+				{7, "Push operand 1: offset base must be bp or sp: [r1+0]"},
+				{8, "expect opcode or label, but got: runtime.Integer(1)"},
+			},
+		},
+		{
+			"compare stack offset",
+			Program{DefLabel(0), Eq, *NewBPOffset(0), Integer(0), Gt, Integer(0), *NewBPOffset(0), Ret},
+			VerifyErrors{
+				{2, "Eq operand 1: want register or immediate, but got: runtime.StackRelativeOffset([bp+0])"},
+				{6, "Gt operand 2: want register or immediate, but got: runtime.StackRelativeOffset([bp+0])"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.prog)
+			var errs VerifyErrors
+			if assert.True(t, errors.As(err, &errs), "%v", err) {
+				assert.Equal(t, tt.expect, errs)
+			}
+		})
+	}
+}
+
+func TestRuntime_Load_Verify(t *testing.T) {
+	prog := Program{DefLabel(0), Call, Integer(1), Ret}
+	// 指定しなければ確かめない
+	rt := NewRuntime(10, 10)
+	assert.Nil(t, rt.Load(prog))
+
+	rt = NewRuntime(10, 10, WithVerify())
+	err := rt.Load(prog)
+	assert.EqualError(t, err, "pc=2: Call operand 1: want label, but got: runtime.Integer(1)")
+	assert.Nil(t, rt.Load(fnProgram()))
+	assert.Nil(t, rt.CollectLabels())
+	assert.Nil(t, rt.Run())
+}